@@ -0,0 +1,134 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	API_MAX_ATTEMPTS = 3
+	API_RETRY_BASE_DELAY = 500 * time.Millisecond
+	API_RETRY_MAX_DELAY = 10 * time.Second
+)
+
+// APIError is a failed DirectCloud API call, either a non-2xx status or a
+// 200 response with success:false.
+type APIError struct {
+	Endpoint string
+	HTTPStatus int
+	ResultCode string
+	Message string
+	// RetryAfter is taken from the Retry-After header when present.
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("DirectCloud API %s failed with HTTP %d", e.Endpoint, e.HTTPStatus)
+	if e.ResultCode != "" {
+		msg += fmt.Sprintf(", result_code %s", e.ResultCode)
+	}
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	return msg
+}
+
+// newAPIError builds an APIError from a response, reading result_code and
+// the "all" message from the error body when it is JSON.
+func newAPIError(endpoint string, resp *http.Response, body []byte) *APIError {
+	e := &APIError{
+		Endpoint: endpoint,
+		HTTPStatus: resp.StatusCode,
+	}
+	var errResp AdminAuthTokenErrorResponse
+	if err := json.Unmarshal(body, &errResp); err == nil {
+		e.ResultCode = errResp.ResultCode
+		e.Message = errResp.All
+	}
+	if e.Message == "" && e.ResultCode == "" && resp.StatusCode != http.StatusOK {
+		e.Message = truncateBody(body)
+	}
+	if v := resp.Header.Get("Retry-After"); v != "" {
+		if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+			e.RetryAfter = time.Duration(secs) * time.Second
+		}
+	}
+	return e
+}
+
+func truncateBody(body []byte) string {
+	const max = 512
+	if len(body) > max {
+		return string(body[:max]) + "..."
+	}
+	return string(body)
+}
+
+// isAuthError reports an invalid or expired token. Retrying will not help and
+// every other call will fail the same way.
+func isAuthError(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.HTTPStatus == http.StatusUnauthorized
+}
+
+// isPermissionError reports a call the token may not make, such as listing a
+// sharedbox the admin has no access to.
+func isPermissionError(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.HTTPStatus == http.StatusForbidden
+}
+
+// isTransientError reports failures worth retrying: throttling, server errors
+// and network errors including per-request timeouts.
+func isTransientError(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.HTTPStatus == http.StatusTooManyRequests ||
+			apiErr.HTTPStatus == http.StatusRequestTimeout ||
+			apiErr.HTTPStatus >= http.StatusInternalServerError
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var urlErr *url.Error
+	return errors.As(err, &urlErr) && !errors.Is(err, context.Canceled)
+}
+
+// withAPIRetry runs call up to API_MAX_ATTEMPTS times while it fails with a
+// transient error, backing off exponentially or as told by Retry-After. It
+// returns the number of attempts made.
+func withAPIRetry(ctx context.Context, call func() error) (int, error) {
+	for attempt := 1; ; attempt++ {
+		err := call()
+		if err == nil || !isTransientError(err) || attempt >= API_MAX_ATTEMPTS || ctx.Err() != nil {
+			return attempt, err
+		}
+		delay := API_RETRY_BASE_DELAY << (attempt - 1)
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+			delay = apiErr.RetryAfter
+		}
+		delay = min(delay, API_RETRY_MAX_DELAY)
+		delay += rand.N(delay / 2)
+		slog.WarnContext(ctx, "Retrying DirectCloud API call",
+			"attempt", attempt,
+			"delay", delay,
+			"error", err,
+			)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return attempt, err
+		case <-timer.C:
+		}
+	}
+}
@@ -1,9 +1,8 @@
 package cmd
 
 import (
-	"encoding/json"
 	"fmt"
-	"os"
+	"time"
 
 	"github.com/spf13/cobra"
 )
@@ -13,23 +12,33 @@ var authCmd = &cobra.Command{
 }
 
 func init() {
-	authCmd.PersistentFlags().Bool("force", false, "Force overwrite existing token file")
 	authCmd.AddCommand(
 		authAdminCmd,
+		authStatusCmd,
+		authLogoutCmd,
 	)
 }
 
 func initAdminApiClient() error {
-	b, err := os.ReadFile("admin_token.json")
+	client, err := loadAdminApiClient()
 	if err != nil {
-		return fmt.Errorf("Failed to open admin_token.json: %v", err)
+		return err
 	}
-	var adminToken AdminAuthTokenResponse
-	err = json.Unmarshal(b, &adminToken)
+	adminApiClient = client
+	return nil
+}
+
+// loadAdminApiClient reads the stored token on every call, so long running
+// processes pick up a token refreshed with `auth admin`.
+func loadAdminApiClient() (*AdminApiClient, error) {
+	token, err := loadAdminToken()
 	if err != nil {
-		return fmt.Errorf("Failed to parse admin_token.json: %v", err)
+		return nil, err
 	}
-	adminApiClient = NewAdminApiClient(adminToken.AccessToken)
-	return nil
+	if token.ExpireTimestamp > 0 && time.Now().Unix() >= token.ExpireTimestamp {
+		return nil, fmt.Errorf("Admin token expired at %s, run `auth admin` again",
+			time.Unix(token.ExpireTimestamp, 0).In(time.Local).Format(time.DateTime))
+	}
+	return NewAdminApiClient(token.AccessToken), nil
 }
 
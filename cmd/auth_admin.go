@@ -11,6 +11,7 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"time"
 
 	"github.com/spf13/cobra"
 )
@@ -20,27 +21,30 @@ var authAdminCmd = &cobra.Command{
 	RunE: runAuthAdminCmd,
 }
 
-func init() {}
+func init() {
+	authAdminCmd.Flags().Bool("password-stdin", false, "Read the admin password from stdin")
+}
 
 func runAuthAdminCmd(cmd *cobra.Command, args []string) error {
 	cmdCtx := cmd.Context()
+	if err := checkSyncTenant(); err != nil {
+		return err
+	}
+	passwordStdin, _ := cmd.Flags().GetBool("password-stdin")
+	credentials, err := readAdminCredentials(passwordStdin)
+	if err != nil {
+		return err
+	}
 	var b bytes.Buffer
 	var fieldErr error
 	w := multipart.NewWriter(&b)
-	if err := w.WriteField("code", os.Getenv("DIRECTCLOUD_CODE")); err != nil {
-		fieldErr = errors.Join(fieldErr, err)
-	}
-	if err := w.WriteField("service", os.Getenv("DIRECTCLOUD_ADMIN_SERVICE")); err != nil {
+	if err := w.WriteField("code", tenant); err != nil {
 		fieldErr = errors.Join(fieldErr, err)
 	}
-	if err := w.WriteField("service_key", os.Getenv("DIRECTCLOUD_ADMIN_SERVICE_KEY")); err != nil {
-		fieldErr = errors.Join(fieldErr, err)
-	}
-	if err := w.WriteField("id", os.Getenv("DIRECTCLOUD_ADMIN_ID")); err != nil {
-		fieldErr = errors.Join(fieldErr, err)
-	}
-	if err := w.WriteField("password", os.Getenv("DIRECTCLOUD_ADMIN_PASSWORD")); err != nil {
-		fieldErr = errors.Join(fieldErr, err)
+	for _, f := range adminCredentialFields {
+		if err := w.WriteField(f.Form, credentials[f.Form]); err != nil {
+			fieldErr = errors.Join(fieldErr, err)
+		}
 	}
 	if fieldErr != nil {
 		slog.ErrorContext(cmdCtx, "Failed to write form fields", "error", fieldErr)
@@ -51,7 +55,7 @@ func runAuthAdminCmd(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("Failed to close multipart writer: %w", err)
 	}
 
-	u, err := url.Parse("https://api.directcloud.jp/openapi/jauth/token")
+	u, err := url.Parse(directcloudBaseURL() + "/openapi/jauth/token")
 	if err != nil {
 		return fmt.Errorf("Failed to parse URL: %w", err)
 	}
@@ -77,7 +81,7 @@ func runAuthAdminCmd(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("Failed to read login response: %w", err)
 	}
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("Login request failed with status: %s, body: %s", resp.Status, string(body))
+		return fmt.Errorf("Login failed: %w", newAPIError(apiEndpointLabel(u.Path), resp, body))
 	}
 
 	token := &AdminAuthTokenResponse{}
@@ -85,15 +89,30 @@ func runAuthAdminCmd(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("Failed to parse login response: %w", err)
 	}
 	if !token.Success || token.AccessToken == "" {
-		return fmt.Errorf("Login failed: %s", string(body))
+		return fmt.Errorf("Login failed: %w", newAPIError(apiEndpointLabel(u.Path), resp, body))
 	}
 
-	err = os.WriteFile("admin_token.json", body, 0600)
+	path, err := saveAdminToken(storedAdminToken{
+		Tenant: tenant,
+		AccessToken: token.AccessToken,
+		ExpireTimestamp: int64(token.ExpireTimestamp),
+		SavedAt: time.Now(),
+	})
 	if err != nil {
-		return fmt.Errorf("Failed to write token to file: %w", err)
+		return fmt.Errorf("Failed to save admin token: %w", err)
 	}
+	slog.InfoContext(cmdCtx, "Admin token saved",
+		"path", path,
+		)
+	fmt.Printf("Admin token saved to %s\n", path)
 
-	slog.InfoContext(cmdCtx, "admin_token.json saved successfully")
-	fmt.Println("admin_token.json saved successfully")
+	if err := os.Remove(ADMIN_TOKEN_LEGACY_FILE); err == nil {
+		fmt.Printf("Removed plaintext %s\n", ADMIN_TOKEN_LEGACY_FILE)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		slog.WarnContext(cmdCtx, "Failed to remove plaintext token file",
+			"file", ADMIN_TOKEN_LEGACY_FILE,
+			"error", err,
+			)
+	}
 	return nil
 }
@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+type adminCredentialField struct {
+	Form string
+	Env string
+	Prompt string
+	Secret bool
+}
+
+var adminCredentialFields = []adminCredentialField{
+	{Form: "service", Env: "DIRECTCLOUD_ADMIN_SERVICE", Prompt: "Service"},
+	{Form: "service_key", Env: "DIRECTCLOUD_ADMIN_SERVICE_KEY", Prompt: "Service key", Secret: true},
+	{Form: "id", Env: "DIRECTCLOUD_ADMIN_ID", Prompt: "Admin ID"},
+	{Form: "password", Env: "DIRECTCLOUD_ADMIN_PASSWORD", Prompt: "Password", Secret: true},
+}
+
+// readAdminCredentials collects the login form fields. Each field comes from
+// its environment variable or from the file named by <VAR>_FILE; the password
+// can also be piped in with --password-stdin. Whatever is still missing is
+// prompted for when stdin is a terminal, with secrets hidden.
+func readAdminCredentials(passwordStdin bool) (map[string]string, error) {
+	values := map[string]string{}
+	for _, f := range adminCredentialFields {
+		v, err := envOrFile(f.Env)
+		if err != nil {
+			return nil, err
+		}
+		values[f.Form] = v
+	}
+	if passwordStdin {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("Failed to read password from stdin: %w", err)
+		}
+		values["password"] = strings.TrimRight(line, "\r\n")
+	} else if term.IsTerminal(int(os.Stdin.Fd())) {
+		reader := bufio.NewReader(os.Stdin)
+		for _, f := range adminCredentialFields {
+			if values[f.Form] != "" {
+				continue
+			}
+			v, err := promptCredential(reader, f)
+			if err != nil {
+				return nil, err
+			}
+			values[f.Form] = v
+		}
+	}
+	var missing []string
+	for _, f := range adminCredentialFields {
+		if values[f.Form] == "" {
+			missing = append(missing, f.Env)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("Missing required credentials: %s (set the variable, its _FILE variant or the config profile)",
+			strings.Join(missing, ", "))
+	}
+	return values, nil
+}
+
+// envOrFile reads name, or the file named by name_FILE with the trailing
+// newline removed, as mounted by Docker and Kubernetes secrets.
+func envOrFile(name string) (string, error) {
+	value := os.Getenv(name)
+	path := os.Getenv(name + "_FILE")
+	if path == "" {
+		return value, nil
+	}
+	if value != "" {
+		return "", fmt.Errorf("Both %s and %s_FILE are set, use only one", name, name)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("Failed to read %s_FILE: %w", name, err)
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+func promptCredential(reader *bufio.Reader, f adminCredentialField) (string, error) {
+	fmt.Fprintf(os.Stderr, "%s: ", f.Prompt)
+	if f.Secret {
+		b, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", fmt.Errorf("Failed to read %s: %w", strings.ToLower(f.Prompt), err)
+		}
+		return string(b), nil
+	}
+	line, err := reader.ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", fmt.Errorf("Failed to read %s: %w", strings.ToLower(f.Prompt), err)
+	}
+	return strings.TrimSpace(line), nil
+}
@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var authLogoutCmd = &cobra.Command{
+	Use:  "logout",
+	RunE: runAuthLogoutCmd,
+}
+
+func init() {}
+
+// runAuthLogoutCmd removes the stored token. The DirectCloud API does not
+// document a way to revoke an access token, so it stays valid on the server
+// until it expires.
+func runAuthLogoutCmd(cmd *cobra.Command, args []string) error {
+	cmdCtx := cmd.Context()
+	path, err := adminTokenPath()
+	if err != nil {
+		return err
+	}
+	// Reading the token is only needed to report its expiry.
+	token, tokenErr := loadAdminToken()
+	removed := 0
+	for _, p := range []string{path, ADMIN_TOKEN_LEGACY_FILE} {
+		err := shredFile(p)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("Failed to remove %s: %w", p, err)
+		}
+		slog.InfoContext(cmdCtx, "Removed admin token",
+			"path", p,
+			)
+		fmt.Printf("Removed %s\n", p)
+		removed++
+	}
+	if removed == 0 {
+		fmt.Println("No stored admin token found")
+		return nil
+	}
+	if tokenErr == nil && token.ExpireTimestamp > 0 {
+		expiresAt := time.Unix(token.ExpireTimestamp, 0).In(time.Local)
+		fmt.Printf("The token cannot be revoked through the API and remains valid on the server until %s\n",
+			expiresAt.Format(time.DateTime))
+	} else {
+		fmt.Println("The token cannot be revoked through the API and remains valid on the server until it expires")
+	}
+	return nil
+}
+
+// shredFile overwrites a file with random bytes before removing it. On
+// copy-on-write or journaling filesystems and SSDs the old blocks may survive,
+// which is why the token is also stored encrypted.
+func shredFile(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := io.CopyN(f, rand.Reader, info.Size()); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// authStatusCmd shows the token of the tenant, or without a tenant the
+// tokens of every tenant.
+var authStatusCmd = &cobra.Command{
+	Use:  "status",
+	Annotations: map[string]string{ANNOTATION_TENANT_OPTIONAL: "true"},
+	RunE: runAuthStatusCmd,
+}
+
+func init() {
+	authStatusCmd.Flags().Bool("offline", false, "Do not call the API to check the token")
+}
+
+func runAuthStatusCmd(cmd *cobra.Command, args []string) error {
+	cmdCtx := cmd.Context()
+	offline, _ := cmd.Flags().GetBool("offline")
+	if tenant != "" {
+		token, err := loadAdminToken()
+		if err != nil {
+			return err
+		}
+		return printAdminTokenStatus(cmdCtx, token, offline)
+	}
+	paths, err := storedAdminTokenPaths()
+	if err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		return fmt.Errorf("No admin token found, run `auth admin --tenant <code>` first")
+	}
+	var statusErr error
+	for i, path := range paths {
+		if i > 0 {
+			fmt.Println()
+		}
+		token, err := readAdminToken(path)
+		if err != nil {
+			fmt.Printf("token:     %s\n", path)
+			fmt.Printf("error:     %v\n", err)
+			statusErr = errors.Join(statusErr, err)
+			continue
+		}
+		statusErr = errors.Join(statusErr, printAdminTokenStatus(cmdCtx, token, offline))
+	}
+	return statusErr
+}
+
+func printAdminTokenStatus(cmdCtx context.Context, token storedAdminToken, offline bool) error {
+	fmt.Printf("tenant:    %s\n", token.Tenant)
+	fmt.Printf("token:     %s\n", token.Source)
+	if token.Source == ADMIN_TOKEN_LEGACY_FILE {
+		fmt.Println("           plaintext, run `auth admin` to store it encrypted")
+	}
+	if !token.SavedAt.IsZero() {
+		fmt.Printf("saved at:  %s\n", token.SavedAt.In(time.Local).Format(time.DateTime))
+	}
+	expired := false
+	if token.ExpireTimestamp > 0 {
+		expiresAt := time.Unix(token.ExpireTimestamp, 0)
+		remaining := time.Until(expiresAt)
+		expired = remaining <= 0
+		fmt.Printf("expires:   %s\n", expiresAt.In(time.Local).Format(time.DateTime))
+		if expired {
+			fmt.Printf("remaining: expired %s ago\n", (-remaining).Round(time.Second))
+		} else {
+			fmt.Printf("remaining: %s\n", remaining.Round(time.Second))
+		}
+	} else {
+		fmt.Println("expires:   unknown")
+	}
+	if offline {
+		return nil
+	}
+	if expired {
+		fmt.Println("api check: skipped, token expired")
+		return fmt.Errorf("Admin token expired")
+	}
+	// Listing the top level sharedboxes is the cheapest authenticated call.
+	ctx, cancel := context.WithTimeout(cmdCtx, 10*time.Second)
+	defer cancel()
+	start := time.Now()
+	_, err := NewAdminApiClient(token.AccessToken).SharedboxesList(ctx, "")
+	if err != nil {
+		fmt.Printf("api check: failed (%v)\n", err)
+		return fmt.Errorf("Admin token check failed: %w", err)
+	}
+	fmt.Printf("api check: ok (%s)\n", time.Since(start).Round(time.Millisecond))
+	return nil
+}
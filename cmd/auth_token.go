@@ -0,0 +1,211 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"filippo.io/age"
+)
+
+const (
+	ADMIN_TOKEN_LEGACY_FILE = "admin_token.json"
+	ADMIN_TOKEN_DIR = "tokens"
+)
+
+// storedAdminToken is everything kept on disk after `auth admin`. The rest of
+// the login response is dropped.
+type storedAdminToken struct {
+	Tenant string `json:"tenant"`
+	AccessToken string `json:"access_token"`
+	ExpireTimestamp int64 `json:"expire_timestamp"`
+	SavedAt time.Time `json:"saved_at"`
+	// Source is the file the token was read from.
+	Source string `json:"-"`
+}
+
+// adminTokenPath returns ADMIN_TOKEN_FILE or
+// <user config dir>/amazing-brain-dead-storage-accessor/tokens/<tenant>.age.
+func adminTokenPath() (string, error) {
+	if path := os.Getenv("ADMIN_TOKEN_FILE"); path != "" {
+		return path, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("Failed to find the user config directory, set ADMIN_TOKEN_FILE: %w", err)
+	}
+	return filepath.Join(dir, CONFIG_APP_DIR, ADMIN_TOKEN_DIR, tenant+".age"), nil
+}
+
+// adminTokenKeys returns the age recipient and identity protecting the token:
+// an X25519 identity from ADMIN_TOKEN_IDENTITY_FILE, or a scrypt key derived
+// from ADMIN_TOKEN_PASSPHRASE or ADMIN_TOKEN_PASSPHRASE_FILE.
+func adminTokenKeys() (age.Recipient, age.Identity, error) {
+	if path := os.Getenv("ADMIN_TOKEN_IDENTITY_FILE"); path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Failed to open age identity file: %w", err)
+		}
+		defer f.Close()
+		identities, err := age.ParseIdentities(f)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Failed to parse age identity file: %w", err)
+		}
+		identity, ok := identities[0].(*age.X25519Identity)
+		if !ok {
+			return nil, nil, fmt.Errorf("Unsupported identity in %s: expected an X25519 identity", path)
+		}
+		return identity.Recipient(), identity, nil
+	}
+	passphrase, err := envOrFile("ADMIN_TOKEN_PASSPHRASE")
+	if err != nil {
+		return nil, nil, err
+	}
+	if passphrase != "" {
+		recipient, err := age.NewScryptRecipient(passphrase)
+		if err != nil {
+			return nil, nil, err
+		}
+		identity, err := age.NewScryptIdentity(passphrase)
+		if err != nil {
+			return nil, nil, err
+		}
+		return recipient, identity, nil
+	}
+	return nil, nil, fmt.Errorf("The admin token is stored encrypted: set ADMIN_TOKEN_PASSPHRASE or ADMIN_TOKEN_IDENTITY_FILE")
+}
+
+func saveAdminToken(token storedAdminToken) (string, error) {
+	path, err := adminTokenPath()
+	if err != nil {
+		return "", err
+	}
+	recipient, _, err := adminTokenKeys()
+	if err != nil {
+		return "", err
+	}
+	plain, err := json.Marshal(token)
+	if err != nil {
+		return "", err
+	}
+	var b bytes.Buffer
+	w, err := age.Encrypt(&b, recipient)
+	if err != nil {
+		return "", fmt.Errorf("Failed to encrypt admin token: %w", err)
+	}
+	if _, err := w.Write(plain); err != nil {
+		return "", fmt.Errorf("Failed to encrypt admin token: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return "", fmt.Errorf("Failed to encrypt admin token: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", fmt.Errorf("Failed to create token directory: %w", err)
+	}
+	// Write to a temporary file first so a crash never leaves half a token.
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".admin-token-*")
+	if err != nil {
+		return "", fmt.Errorf("Failed to write admin token: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b.Bytes()); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("Failed to write admin token: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", fmt.Errorf("Failed to write admin token: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", fmt.Errorf("Failed to write admin token: %w", err)
+	}
+	return path, nil
+}
+
+// loadAdminToken reads the encrypted token. A plaintext admin_token.json in
+// the working directory is still accepted so existing setups keep working
+// until `auth admin` is run again.
+func loadAdminToken() (storedAdminToken, error) {
+	path, err := adminTokenPath()
+	if err != nil {
+		return storedAdminToken{}, err
+	}
+	token, err := readAdminToken(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return loadLegacyAdminToken(path)
+	}
+	if err != nil {
+		return token, err
+	}
+	if token.Tenant != tenant {
+		return token, fmt.Errorf("Admin token %s belongs to tenant %q, not %q", path, token.Tenant, tenant)
+	}
+	return token, nil
+}
+
+// storedAdminTokenPaths returns the encrypted tokens of every tenant.
+func storedAdminTokenPaths() ([]string, error) {
+	if path := os.Getenv("ADMIN_TOKEN_FILE"); path != "" {
+		return []string{path}, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to find the user config directory, set ADMIN_TOKEN_FILE: %w", err)
+	}
+	return filepath.Glob(filepath.Join(dir, CONFIG_APP_DIR, ADMIN_TOKEN_DIR, "*.age"))
+}
+
+// readAdminToken decrypts the token stored at path.
+func readAdminToken(path string) (storedAdminToken, error) {
+	var token storedAdminToken
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return token, err
+	}
+	if err != nil {
+		return token, fmt.Errorf("Failed to read admin token: %w", err)
+	}
+	_, identity, err := adminTokenKeys()
+	if err != nil {
+		return token, err
+	}
+	r, err := age.Decrypt(bytes.NewReader(b), identity)
+	if err != nil {
+		return token, fmt.Errorf("Failed to decrypt admin token %s: %w", path, err)
+	}
+	plain, err := io.ReadAll(r)
+	if err != nil {
+		return token, fmt.Errorf("Failed to decrypt admin token %s: %w", path, err)
+	}
+	if err := json.Unmarshal(plain, &token); err != nil {
+		return token, fmt.Errorf("Failed to parse admin token %s: %w", path, err)
+	}
+	token.Source = path
+	return token, nil
+}
+
+func loadLegacyAdminToken(path string) (storedAdminToken, error) {
+	var token storedAdminToken
+	b, err := os.ReadFile(ADMIN_TOKEN_LEGACY_FILE)
+	if errors.Is(err, os.ErrNotExist) {
+		return token, fmt.Errorf("No admin token found at %s, run `auth admin` first", path)
+	}
+	if err != nil {
+		return token, fmt.Errorf("Failed to open %s: %v", ADMIN_TOKEN_LEGACY_FILE, err)
+	}
+	var legacy AdminAuthTokenResponse
+	if err := json.Unmarshal(b, &legacy); err != nil {
+		return token, fmt.Errorf("Failed to parse %s: %v", ADMIN_TOKEN_LEGACY_FILE, err)
+	}
+	slog.Warn("Using plaintext admin_token.json, run `auth admin` to store the token encrypted")
+	token.Tenant = tenant
+	token.Source = ADMIN_TOKEN_LEGACY_FILE
+	token.AccessToken = legacy.AccessToken
+	token.ExpireTimestamp = int64(legacy.ExpireTimestamp)
+	return token, nil
+}
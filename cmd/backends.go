@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	ANNOTATION_BACKENDS = "backends"
+	BACKEND_STORAGE = "storage"
+	// BACKEND_STORAGE_WRITE is BACKEND_STORAGE for commands that write to
+	// the mirror, which must not run on a schema with pending migrations.
+	BACKEND_STORAGE_WRITE = "storage_write"
+	BACKEND_REDIS = "redis"
+	// BACKEND_MONGO connects to Mongo without going through the storage
+	// backend, for commands that manage the Mongo mirror itself.
+	BACKEND_MONGO = "mongo"
+)
+
+// withBackends is used as cobra.Command.Annotations to declare the backends a
+// command needs. Commands without the annotation inherit it from their parent,
+// and commands that declare nothing connect to nothing.
+func withBackends(backends ...string) map[string]string {
+	return map[string]string{
+		ANNOTATION_BACKENDS: strings.Join(backends, ","),
+	}
+}
+
+func needsBackend(cmd *cobra.Command, backend string) bool {
+	for c := cmd; c != nil; c = c.Parent() {
+		if v, ok := c.Annotations[ANNOTATION_BACKENDS]; ok {
+			return slices.Contains(strings.Split(v, ","), backend)
+		}
+	}
+	return false
+}
+
+// initBackends connects to the backends declared by cmd.
+func initBackends(cmd *cobra.Command) error {
+	if needsBackend(cmd, BACKEND_STORAGE) || needsBackend(cmd, BACKEND_STORAGE_WRITE) {
+		if err := initStorage(cmd); err != nil {
+			return err
+		}
+	}
+	if needsBackend(cmd, BACKEND_MONGO) {
+		if err := initMongoClient(cmd); err != nil {
+			return err
+		}
+	}
+	if needsBackend(cmd, BACKEND_REDIS) {
+		if err := initRedisClient(cmd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,244 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/joho/godotenv"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	CONFIG_APP_DIR = "amazing-brain-dead-storage-accessor"
+	CONFIG_FILE_NAME = "config.yaml"
+)
+
+const (
+	CONFIG_SOURCE_FLAG = "flag"
+	CONFIG_SOURCE_ENV = "env"
+	CONFIG_SOURCE_PROFILE = "profile"
+	CONFIG_SOURCE_DEFAULT = "default"
+)
+
+// configSetting maps a key of a config profile to the environment variable
+// the rest of the code reads. Profile values are applied the same way
+// godotenv applies .env: only when the variable is not already set, which
+// gives flags > env > profile > defaults.
+type configSetting struct {
+	Key string
+	Env string
+	Flag string
+	Default string
+	// DefaultFunc replaces Default for settings whose default depends on
+	// other settings.
+	DefaultFunc func() string
+	Secret bool
+}
+
+var configSettings = []configSetting{
+	{Key: "directcloud.code", Env: "DIRECTCLOUD_CODE", Flag: "tenant"},
+	{Key: "directcloud.admin_service", Env: "DIRECTCLOUD_ADMIN_SERVICE"},
+	{Key: "directcloud.admin_service_key", Env: "DIRECTCLOUD_ADMIN_SERVICE_KEY", Secret: true},
+	{Key: "directcloud.admin_id", Env: "DIRECTCLOUD_ADMIN_ID"},
+	{Key: "directcloud.admin_password", Env: "DIRECTCLOUD_ADMIN_PASSWORD", Secret: true},
+	{Key: "directcloud.admin_service_key_file", Env: "DIRECTCLOUD_ADMIN_SERVICE_KEY_FILE"},
+	{Key: "directcloud.admin_password_file", Env: "DIRECTCLOUD_ADMIN_PASSWORD_FILE"},
+	{Key: "directcloud.api_base_url", Env: "DIRECTCLOUD_API_BASE_URL", Default: DIRECTCLOUD_DEFAULT_API_BASE_URL},
+	{Key: "directcloud.files_list_path", Env: "DIRECTCLOUD_FILES_LIST_PATH", Default: DIRECTCLOUD_DEFAULT_FILES_LIST_PATH},
+	{Key: "directcloud.files_download_path", Env: "DIRECTCLOUD_FILES_DOWNLOAD_PATH", Default: DIRECTCLOUD_DEFAULT_FILES_DOWNLOAD_PATH},
+	{Key: "auth.token_file", Env: "ADMIN_TOKEN_FILE"},
+	{Key: "auth.identity_file", Env: "ADMIN_TOKEN_IDENTITY_FILE"},
+	{Key: "auth.passphrase", Env: "ADMIN_TOKEN_PASSPHRASE", Secret: true},
+	{Key: "storage.backend", Env: "STORAGE_BACKEND", Default: STORAGE_MONGO},
+	{Key: "storage.sqlite_path", Env: "SQLITE_PATH", Default: SQLITE_DEFAULT_PATH},
+	{Key: "mongo.uri", Env: "MONGO_URI", Secret: true},
+	{Key: "mongo.host", Env: "MONGO_HOST"},
+	{Key: "mongo.port", Env: "MONGO_PORT"},
+	{Key: "mongo.database", Env: "MONGO_DATABASE", Default: MONGO_DEFAULT_DATABASE},
+	{Key: "mongo.username", Env: "MONGO_USERNAME"},
+	{Key: "mongo.password", Env: "MONGO_PASSWORD", Secret: true},
+	{Key: "mongo.password_file", Env: "MONGO_PASSWORD_FILE"},
+	{Key: "mongo.auth_source", Env: "MONGO_AUTH_SOURCE"},
+	{Key: "mongo.auth_mechanism", Env: "MONGO_AUTH_MECHANISM"},
+	{Key: "mongo.tls_ca_file", Env: "MONGO_TLS_CA_FILE"},
+	{Key: "mongo.tls_cert_file", Env: "MONGO_TLS_CERT_FILE"},
+	{Key: "mongo.tls_key_file", Env: "MONGO_TLS_KEY_FILE"},
+	{Key: "redis.url", Env: "REDIS_URL", Secret: true},
+	{Key: "redis.host", Env: "REDIS_HOST"},
+	{Key: "redis.port", Env: "REDIS_PORT"},
+	{Key: "redis.db", Env: "REDIS_DB", Default: "0"},
+	{Key: "redis.username", Env: "REDIS_USERNAME"},
+	{Key: "redis.password", Env: "REDIS_PASSWORD", Secret: true},
+	{Key: "redis.password_file", Env: "REDIS_PASSWORD_FILE"},
+	{Key: "redis.mode", Env: "REDIS_MODE", Default: REDIS_MODE_STANDALONE},
+	{Key: "redis.addrs", Env: "REDIS_ADDRS"},
+	{Key: "redis.sentinel_master", Env: "REDIS_SENTINEL_MASTER"},
+	{Key: "redis.sentinel_username", Env: "REDIS_SENTINEL_USERNAME"},
+	{Key: "redis.sentinel_password", Env: "REDIS_SENTINEL_PASSWORD", Secret: true},
+	{Key: "redis.tls", Env: "REDIS_TLS"},
+	{Key: "redis.tls_ca_file", Env: "REDIS_TLS_CA_FILE"},
+	{Key: "redis.tls_cert_file", Env: "REDIS_TLS_CERT_FILE"},
+	{Key: "redis.tls_key_file", Env: "REDIS_TLS_KEY_FILE"},
+	{Key: "redis.key_prefix", Env: "REDIS_KEY_PREFIX"},
+	{Key: "errors.sink", Env: "ERROR_SINK", DefaultFunc: errorSinkKind},
+	{Key: "errors.dir", Env: "ERROR_SINK_DIR", Default: DIRECTORY_DEFAULT_LOGS},
+	{Key: "errors.ttl", Env: "ERROR_SINK_TTL", Default: ERROR_SINK_DEFAULT_TTL.String()},
+	{Key: "export.dir", Env: "EXPORT_DIR", Flag: "dir", Default: DIRECTORY_DEFAULT_EXPORT},
+	{Key: "export.excludes", Env: "DEFAULT_EXPORT_EXCLUDES"},
+	{Key: "export.includes", Env: "DEFAULT_EXPORT_INCLUDES"},
+	{Key: "log.format", Env: "LOG_FORMAT", Flag: "log-format", Default: "text"},
+	{Key: "log.dir", Env: "LOG_DIR", Flag: "log-dir", Default: DIRECTORY_DEFAULT_LOGS},
+	{Key: "log.file", Env: "LOG_FILE", Flag: "log-file"},
+	{Key: "log.stderr", Env: "LOG_STDERR", Flag: "log-stderr", Default: "off"},
+	{Key: "log.max_size", Env: "LOG_MAX_SIZE", Flag: "log-max-size", Default: "0"},
+	{Key: "log.max_age", Env: "LOG_MAX_AGE", Flag: "log-max-age", Default: "0s"},
+	{Key: "log.retention", Env: "LOG_RETENTION", Flag: "log-retention", Default: "0s"},
+	{Key: "log.max_files", Env: "LOG_MAX_FILES", Flag: "log-max-files", Default: "0"},
+	{Key: "trace.exporter", Env: "TRACE_EXPORTER", Flag: "trace-exporter", Default: TRACE_EXPORTER_NONE},
+	{Key: "serve.api_token", Env: "API_TOKEN", Secret: true},
+	{Key: "daemon.user_sync_schedule", Env: "DAEMON_USER_SYNC_SCHEDULE", Flag: "user-sync-schedule"},
+	{Key: "daemon.sharedbox_sync_schedule", Env: "DAEMON_SHAREDBOX_SYNC_SCHEDULE", Flag: "sharedbox-sync-schedule"},
+}
+
+type configFile struct {
+	DefaultProfile string `yaml:"default_profile"`
+	Profiles map[string]map[string]any `yaml:"profiles"`
+}
+
+var (
+	configPath string
+	configProfile string
+	configSources = map[string]string{}
+)
+
+// initSettings loads .env and the selected config profile into the
+// environment. It runs before anything reads configuration.
+func initSettings(cmd *cobra.Command) error {
+	_ = godotenv.Load()
+	for _, s := range configSettings {
+		if os.Getenv(s.Env) != "" {
+			configSources[s.Env] = CONFIG_SOURCE_ENV
+		}
+	}
+	path := flagOrEnv(cmd, "config", "ABDSA_CONFIG")
+	explicit := path != ""
+	if !explicit {
+		path = defaultConfigPath()
+	}
+	profileName := flagOrEnv(cmd, "profile", "ABDSA_PROFILE")
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) && !explicit && profileName == "" {
+			return nil
+		}
+		return fmt.Errorf("Failed to read config file: %w", err)
+	}
+	var cfg configFile
+	if err := yaml.Unmarshal(b, &cfg); err != nil {
+		return fmt.Errorf("Failed to parse config file %s: %w", path, err)
+	}
+	configPath = path
+	if profileName == "" {
+		profileName = cfg.DefaultProfile
+	}
+	if profileName == "" {
+		return nil
+	}
+	profile, ok := cfg.Profiles[profileName]
+	if !ok {
+		return fmt.Errorf("Profile %q not found in %s", profileName, path)
+	}
+	configProfile = profileName
+	values := map[string]string{}
+	if err := flattenConfig("", profile, values); err != nil {
+		return fmt.Errorf("Invalid profile %q in %s: %w", profileName, path, err)
+	}
+	for key, value := range values {
+		i := slices.IndexFunc(configSettings, func(s configSetting) bool {
+			return s.Key == key
+		})
+		if i < 0 {
+			return fmt.Errorf("Unknown setting %q in profile %q", key, profileName)
+		}
+		// Empty values, as left by .env.sample, do not hide the profile.
+		env := configSettings[i].Env
+		if os.Getenv(env) != "" {
+			continue
+		}
+		os.Setenv(env, value)
+		configSources[env] = CONFIG_SOURCE_PROFILE
+	}
+	return nil
+}
+
+// defaultConfigPath prefers ./config.yaml and falls back to the XDG config
+// directory.
+func defaultConfigPath() string {
+	if _, err := os.Stat(CONFIG_FILE_NAME); err == nil {
+		return CONFIG_FILE_NAME
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return CONFIG_FILE_NAME
+	}
+	return filepath.Join(dir, CONFIG_APP_DIR, CONFIG_FILE_NAME)
+}
+
+// flattenConfig turns nested profile maps into dotted keys. Lists become
+// comma separated values, matching how the environment variables are read.
+func flattenConfig(prefix string, m map[string]any, out map[string]string) error {
+	for k, v := range m {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+		switch v := v.(type) {
+		case map[string]any:
+			if err := flattenConfig(key, v, out); err != nil {
+				return err
+			}
+		case []any:
+			parts := make([]string, 0, len(v))
+			for _, item := range v {
+				parts = append(parts, fmt.Sprint(item))
+			}
+			out[key] = strings.Join(parts, ",")
+		case nil:
+		default:
+			out[key] = fmt.Sprint(v)
+		}
+	}
+	return nil
+}
+
+// effectiveSetting returns the value in use for s and where it came from.
+func effectiveSetting(cmd *cobra.Command, s configSetting) (string, string) {
+	if s.Flag != "" {
+		if f := cmd.Flags().Lookup(s.Flag); f != nil && f.Changed {
+			return f.Value.String(), CONFIG_SOURCE_FLAG
+		}
+	}
+	if v := os.Getenv(s.Env); v != "" {
+		source := configSources[s.Env]
+		if source == "" {
+			source = CONFIG_SOURCE_ENV
+		}
+		return v, source
+	}
+	if s.DefaultFunc != nil {
+		return s.DefaultFunc(), CONFIG_SOURCE_DEFAULT
+	}
+	return s.Default, CONFIG_SOURCE_DEFAULT
+}
+
+func maskSecret(value string) string {
+	if value == "" {
+		return ""
+	}
+	return "********"
+}
@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+var configCmd = &cobra.Command{
+	Use: "config",
+	// Showing the configuration must not require any backend.
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		return initSettings(cmd)
+	},
+	PersistentPostRunE: func(cmd *cobra.Command, args []string) error {
+		return nil
+	},
+}
+
+var configShowCmd = &cobra.Command{
+	Use: "show",
+	RunE: runConfigShowCmd,
+}
+
+func init() {
+	configCmd.AddCommand(
+		configShowCmd,
+		)
+}
+
+func runConfigShowCmd(cmd *cobra.Command, args []string) error {
+	path := configPath
+	if path == "" {
+		path = "(none)"
+	}
+	profile := configProfile
+	if profile == "" {
+		profile = "(none)"
+	}
+	fmt.Printf("config file: %s\n", path)
+	fmt.Printf("profile:     %s\n\n", profile)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SETTING\tENV\tVALUE\tSOURCE")
+	for _, s := range configSettings {
+		value, source := effectiveSetting(cmd, s)
+		if s.Secret {
+			value = maskSecret(value)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", s.Key, s.Env, value, source)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,136 @@
+package cmd
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func TestFlattenConfig(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		in map[string]any
+		want map[string]string
+	}{
+		{
+			name: "nested maps",
+			in: map[string]any{
+				"mongo": map[string]any{"host": "db", "port": 27017},
+				"log": map[string]any{"format": "json"},
+			},
+			want: map[string]string{"mongo.host": "db", "mongo.port": "27017", "log.format": "json"},
+		},
+		{
+			name: "lists are comma separated",
+			in: map[string]any{
+				"redis": map[string]any{"addrs": []any{"a:6379", "b:6379"}},
+			},
+			want: map[string]string{"redis.addrs": "a:6379,b:6379"},
+		},
+		{
+			name: "null values are skipped",
+			in: map[string]any{
+				"redis": map[string]any{"url": nil, "tls": true},
+			},
+			want: map[string]string{"redis.tls": "true"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := map[string]string{}
+			if err := flattenConfig("", tc.in, got); err != nil {
+				t.Fatal(err)
+			}
+			if !maps.Equal(got, tc.want) {
+				t.Errorf("got %v, expected %v", got, tc.want)
+			}
+		})
+	}
+}
+
+// testConfigCommand returns a command with the flags initSettings and
+// effectiveSetting read, parsed from args.
+func testConfigCommand(t *testing.T, args ...string) *cobra.Command {
+	t.Helper()
+	cmd := &cobra.Command{}
+	cmd.Flags().String("config", "", "")
+	cmd.Flags().String("profile", "", "")
+	cmd.Flags().String("log-format", "text", "")
+	if err := cmd.Flags().Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return cmd
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	// initSettings writes profile values to the environment; t.Setenv
+	// restores every variable afterwards.
+	for _, s := range configSettings {
+		t.Setenv(s.Env, "")
+	}
+	t.Setenv("ABDSA_CONFIG", "")
+	t.Setenv("ABDSA_PROFILE", "")
+	previous := configSources
+	configSources = map[string]string{}
+	t.Cleanup(func() {
+		configSources = previous
+	})
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(path, []byte(strings.Join([]string{
+		"default_profile: staging",
+		"profiles:",
+		"  staging:",
+		"    mongo:",
+		"      host: profile-host",
+		"      database: profile-db",
+		"    log:",
+		"      format: text",
+		"    redis:",
+		"      addrs: [a:6379, b:6379]",
+		"",
+	}, "\n")), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("MONGO_HOST", "env-host")
+	cmd := testConfigCommand(t, "--config", path, "--log-format", "json")
+	if err := initSettings(cmd); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		env string
+		want string
+		source string
+	}{
+		{env: "LOG_FORMAT", want: "json", source: CONFIG_SOURCE_FLAG},
+		{env: "MONGO_HOST", want: "env-host", source: CONFIG_SOURCE_ENV},
+		{env: "MONGO_DATABASE", want: "profile-db", source: CONFIG_SOURCE_PROFILE},
+		{env: "REDIS_ADDRS", want: "a:6379,b:6379", source: CONFIG_SOURCE_PROFILE},
+		{env: "SQLITE_PATH", want: SQLITE_DEFAULT_PATH, source: CONFIG_SOURCE_DEFAULT},
+	} {
+		i := slices.IndexFunc(configSettings, func(s configSetting) bool {
+			return s.Env == tc.env
+		})
+		value, source := effectiveSetting(cmd, configSettings[i])
+		if value != tc.want || source != tc.source {
+			t.Errorf("%s = %q from %s, expected %q from %s", tc.env, value, source, tc.want, tc.source)
+		}
+	}
+}
+
+func TestConfigUnknownSetting(t *testing.T) {
+	t.Setenv("ABDSA_PROFILE", "")
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(path, []byte("default_profile: p\nprofiles:\n  p:\n    mongo:\n      hots: typo\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := testConfigCommand(t, "--config", path)
+	if err := initSettings(cmd); err == nil || !strings.Contains(err.Error(), "mongo.hots") {
+		t.Fatalf("initSettings = %v, expected an unknown setting error", err)
+	}
+}
@@ -0,0 +1,311 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/robfig/cron/v3"
+	"github.com/spf13/cobra"
+)
+
+var daemonCmd = &cobra.Command{
+	Use: "daemon",
+	Annotations: withBackends(BACKEND_STORAGE_WRITE),
+	RunE: runDaemonCmd,
+}
+
+func init() {
+	daemonCmd.Flags().String("addr", ":8080", "Listen address for /healthz and /status (empty to disable)")
+	daemonCmd.Flags().String("user-sync-schedule", "", "Cron expression for user sync (env DAEMON_USER_SYNC_SCHEDULE)")
+	daemonCmd.Flags().String("sharedbox-sync-schedule", "", "Cron expression for sharedbox sync (env DAEMON_SHAREDBOX_SYNC_SCHEDULE)")
+	daemonCmd.Flags().String("sharedbox-node", "", "Node to start the scheduled sharedbox sync from")
+	daemonCmd.Flags().Bool("sharedbox-recursive", true, "Sync sharedboxes recursively on schedule")
+	daemonCmd.Flags().Duration("jitter", time.Minute, "Maximum random delay added before each scheduled run")
+	daemonCmd.Flags().Duration("shutdown-timeout", 5*time.Minute, "How long to wait for running syncs before cancelling them on shutdown")
+}
+
+type daemonJobStatus struct {
+	Name string `json:"name"`
+	Schedule string `json:"schedule"`
+	Running bool `json:"running"`
+	NextRun time.Time `json:"next_run"`
+	LastStart *time.Time `json:"last_start,omitempty"`
+	LastEnd *time.Time `json:"last_end,omitempty"`
+	LastDuration string `json:"last_duration,omitempty"`
+	LastError string `json:"last_error,omitempty"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	Runs int `json:"runs"`
+	Failures int `json:"failures"`
+	Skipped int `json:"skipped"`
+}
+
+// daemonJob is one scheduled sync. A run that fires while the previous one
+// is still going is skipped rather than queued.
+type daemonJob struct {
+	name string
+	spec string
+	jitter time.Duration
+	run func(ctx context.Context) error
+	entryID cron.EntryID
+
+	running atomic.Bool
+	mu sync.Mutex
+	status daemonJobStatus
+}
+
+// trigger runs the job on ctx. The jitter delay is cut short by shutdownCtx,
+// as no run has started yet that would need to finish.
+func (j *daemonJob) trigger(ctx context.Context, shutdownCtx context.Context) {
+	if !j.running.CompareAndSwap(false, true) {
+		j.mu.Lock()
+		j.status.Skipped++
+		j.mu.Unlock()
+		slog.WarnContext(ctx, "Previous run still in progress, skipping",
+			"job", j.name,
+			)
+		return
+	}
+	defer j.running.Store(false)
+	if j.jitter > 0 {
+		delay := rand.N(j.jitter)
+		slog.DebugContext(ctx, "Delaying scheduled run",
+			"job", j.name,
+			"delay", delay,
+			)
+		select {
+		case <-ctx.Done():
+			return
+		case <-shutdownCtx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+
+	start := time.Now()
+	j.mu.Lock()
+	j.status.LastStart = &start
+	j.status.Runs++
+	j.mu.Unlock()
+	slog.InfoContext(ctx, "Scheduled run started",
+		"job", j.name,
+		)
+
+	err := j.run(ctx)
+
+	end := time.Now()
+	j.mu.Lock()
+	j.status.LastEnd = &end
+	j.status.LastDuration = end.Sub(start).Round(time.Millisecond).String()
+	if err != nil {
+		j.status.Failures++
+		j.status.LastError = err.Error()
+	} else {
+		j.status.LastError = ""
+		j.status.LastSuccess = &end
+	}
+	j.mu.Unlock()
+	if err != nil {
+		slog.ErrorContext(ctx, "Scheduled run failed",
+			"job", j.name,
+			"duration", end.Sub(start),
+			"error", err,
+			)
+		return
+	}
+	slog.InfoContext(ctx, "Scheduled run finished",
+		"job", j.name,
+		"duration", end.Sub(start),
+		)
+}
+
+func (j *daemonJob) snapshot(c *cron.Cron) daemonJobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	status := j.status
+	status.Name = j.name
+	status.Schedule = j.spec
+	status.Running = j.running.Load()
+	status.NextRun = c.Entry(j.entryID).Next
+	return status
+}
+
+func runDaemonCmd(cmd *cobra.Command, args []string) error {
+	cmdCtx := cmd.Context()
+	addr, _ := cmd.Flags().GetString("addr")
+	jitter, _ := cmd.Flags().GetDuration("jitter")
+	shutdownTimeout, _ := cmd.Flags().GetDuration("shutdown-timeout")
+	userSchedule, _ := cmd.Flags().GetString("user-sync-schedule")
+	if userSchedule == "" {
+		userSchedule = os.Getenv("DAEMON_USER_SYNC_SCHEDULE")
+	}
+	sharedboxSchedule, _ := cmd.Flags().GetString("sharedbox-sync-schedule")
+	if sharedboxSchedule == "" {
+		sharedboxSchedule = os.Getenv("DAEMON_SHAREDBOX_SYNC_SCHEDULE")
+	}
+	sharedboxNode, _ := cmd.Flags().GetString("sharedbox-node")
+	sharedboxRecursive, _ := cmd.Flags().GetBool("sharedbox-recursive")
+
+	var jobs []*daemonJob
+	if userSchedule != "" {
+		jobs = append(jobs, &daemonJob{
+			name: "user-sync",
+			spec: userSchedule,
+			jitter: jitter,
+			run: func(ctx context.Context) error {
+				client, err := loadAdminApiClient()
+				if err != nil {
+					return err
+				}
+				return runUserSync(ctx, client, userSyncOptions{})
+			},
+		})
+	}
+	if sharedboxSchedule != "" {
+		jobs = append(jobs, &daemonJob{
+			name: "sharedbox-sync",
+			spec: sharedboxSchedule,
+			jitter: jitter,
+			run: func(ctx context.Context) error {
+				client, err := loadAdminApiClient()
+				if err != nil {
+					return err
+				}
+				return runSharedboxSync(ctx, client, sharedboxSyncOptions{
+					RootNode: sharedboxNode,
+					Recursive: sharedboxRecursive,
+					QueueSize: SHAREDBOX_SYNC_DEFAULT_QUEUE_SIZE,
+					Spill: NODE_QUEUE_SPILL_DISK,
+					Quiet: true,
+				})
+			},
+		})
+	}
+	if len(jobs) == 0 {
+		return fmt.Errorf("No schedule configured; set --user-sync-schedule and/or --sharedbox-sync-schedule")
+	}
+	// Connect once here rather than in the first run of each job, which may
+	// run concurrently.
+	if err := initSyncRedis(cmdCtx, sharedboxSchedule != "" && errorSinkUsesRedis()); err != nil {
+		return err
+	}
+
+	// Running syncs get their own context so that a shutdown signal stops
+	// scheduling immediately but lets them finish within shutdownTimeout.
+	jobsCtx, jobsCancel := context.WithCancel(context.WithoutCancel(cmdCtx))
+	defer jobsCancel()
+	signalCtx, stop := signal.NotifyContext(cmdCtx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	c := cron.New(cron.WithLocation(time.Local))
+	for _, job := range jobs {
+		schedule, err := cron.ParseStandard(job.spec)
+		if err != nil {
+			return fmt.Errorf("Invalid schedule %q for %s: %w", job.spec, job.name, err)
+		}
+		job.entryID = c.Schedule(schedule, cron.FuncJob(func() {
+			job.trigger(jobsCtx, signalCtx)
+		}))
+	}
+
+	var server *http.Server
+	if addr != "" {
+		mux := http.NewServeMux()
+		mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+			defer cancel()
+			var pingErr error
+			if err := store.Ping(ctx); err != nil {
+				pingErr = errors.Join(pingErr, fmt.Errorf("%s: %w", storageBackend, err))
+			}
+			if redisClient != nil {
+				if err := redisClient.Ping(ctx).Err(); err != nil {
+					pingErr = errors.Join(pingErr, fmt.Errorf("redis: %w", err))
+				}
+			}
+			if pingErr != nil {
+				writeJSON(w, http.StatusServiceUnavailable, map[string]string{
+					"status": "unhealthy",
+					"error": pingErr.Error(),
+				})
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]string{
+				"status": "ok",
+			})
+		})
+		mux.Handle("GET /metrics", promhttp.Handler())
+		mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+			statuses := make([]daemonJobStatus, 0, len(jobs))
+			for _, job := range jobs {
+				statuses = append(statuses, job.snapshot(c))
+			}
+			writeJSON(w, http.StatusOK, map[string]any{
+				"session_id": sessionID,
+				"jobs": statuses,
+			})
+		})
+		server = &http.Server{
+			Addr: addr,
+			Handler: mux,
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		// Bind before scheduling anything, so that a taken port fails the
+		// daemon instead of leaving it running without health checks.
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			return fmt.Errorf("Failed to listen on %s: %w", addr, err)
+		}
+		go func() {
+			if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				slog.ErrorContext(cmdCtx, "Status server failed",
+					"error", err,
+					)
+			}
+		}()
+	}
+
+	c.Start()
+	for _, job := range jobs {
+		slog.InfoContext(cmdCtx, "Scheduled sync",
+			"job", job.name,
+			"schedule", job.spec,
+			"next", c.Entry(job.entryID).Next,
+			)
+	}
+	fmt.Printf("daemon started with %d job(s)\n", len(jobs))
+
+	<-signalCtx.Done()
+	slog.InfoContext(cmdCtx, "Shutting down daemon")
+	stopped := c.Stop()
+	select {
+	case <-stopped.Done():
+	case <-time.After(shutdownTimeout):
+		slog.WarnContext(cmdCtx, "Running syncs did not finish in time, cancelling",
+			"timeout", shutdownTimeout,
+			)
+		jobsCancel()
+		<-stopped.Done()
+	}
+	if server != nil {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(cmdCtx), 5*time.Second)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			slog.ErrorContext(cmdCtx, "Failed to shut down status server",
+				"error", err,
+				)
+		}
+	}
+	slog.InfoContext(cmdCtx, "daemon stopped")
+	return nil
+}
@@ -0,0 +1,25 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var dbCmd = &cobra.Command{
+	Use: "db",
+	Annotations: withBackends(BACKEND_MONGO),
+}
+
+var dbMigrateCmd = &cobra.Command{
+	Use: "migrate",
+}
+
+func init() {
+	dbCmd.AddCommand(
+		dbMigrateCmd,
+		)
+	dbMigrateCmd.AddCommand(
+		dbMigrateStatusCmd,
+		dbMigrateUpCmd,
+		dbMigrateDownCmd,
+		)
+}
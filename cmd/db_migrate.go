@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var dbMigrateStatusCmd = &cobra.Command{
+	Use: "status",
+	Annotations: map[string]string{ANNOTATION_TENANT_OPTIONAL: "true"},
+	RunE: runDbMigrateStatusCmd,
+}
+
+var dbMigrateUpCmd = &cobra.Command{
+	Use: "up",
+	RunE: runDbMigrateUpCmd,
+}
+
+var dbMigrateDownCmd = &cobra.Command{
+	Use: "down",
+	RunE: runDbMigrateDownCmd,
+}
+
+func init() {
+	dbMigrateUpCmd.Flags().Int("to", 0, "Apply migrations up to this version (default: latest)")
+	dbMigrateDownCmd.Flags().Int("to", -1, "Revert migrations newer than this version (default: the latest applied one only)")
+}
+
+func runDbMigrateStatusCmd(cmd *cobra.Command, args []string) error {
+	cmdCtx := cmd.Context()
+	db := mongoClient.Database(mongoDatabase)
+	applied, err := appliedMigrations(cmdCtx, db)
+	if err != nil {
+		return err
+	}
+	current := 0
+	for _, m := range mongoMigrations {
+		if _, ok := applied[m.Version]; ok {
+			current = m.Version
+		}
+	}
+	fmt.Printf("database:       %s\n", mongoDatabase)
+	fmt.Printf("schema version: %d of %d\n\n", current, latestMigrationVersion())
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tSTATUS\tAPPLIED AT")
+	for _, m := range mongoMigrations {
+		status, appliedAt := "pending", ""
+		if a, ok := applied[m.Version]; ok {
+			status = "applied"
+			appliedAt = a.AppliedAt.In(time.Local).Format(time.DateTime)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", m.Version, m.Name, status, appliedAt)
+	}
+	return w.Flush()
+}
+
+func runDbMigrateUpCmd(cmd *cobra.Command, args []string) error {
+	cmdCtx := cmd.Context()
+	target, _ := cmd.Flags().GetInt("to")
+	count, err := migrateUp(cmdCtx, mongoClient.Database(mongoDatabase), target)
+	if count > 0 {
+		fmt.Printf("Applied %d migrations\n", count)
+	}
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		fmt.Println("No pending migrations")
+	}
+	return nil
+}
+
+func runDbMigrateDownCmd(cmd *cobra.Command, args []string) error {
+	cmdCtx := cmd.Context()
+	db := mongoClient.Database(mongoDatabase)
+	target, _ := cmd.Flags().GetInt("to")
+	if target < 0 {
+		applied, err := appliedMigrations(cmdCtx, db)
+		if err != nil {
+			return err
+		}
+		target = 0
+		for _, m := range mongoMigrations {
+			if _, ok := applied[m.Version]; ok {
+				target = max(target, m.Version-1)
+			}
+		}
+	}
+	count, err := migrateDown(cmdCtx, db, target)
+	if count > 0 {
+		fmt.Printf("Reverted %d migrations\n", count)
+	}
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		fmt.Println("No migrations to revert")
+	}
+	return nil
+}
@@ -0,0 +1,282 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	ERROR_SINK_REDIS = "redis"
+	ERROR_SINK_MONGO = "mongo"
+	ERROR_SINK_NDJSON = "ndjson"
+	ERROR_SINK_DEFAULT_TTL = 7 * 24 * time.Hour
+	MONGO_COLLECTION_SYNC_ERRORS = "sync_errors"
+)
+
+const (
+	ERROR_CLASS_AUTH = "auth"
+	ERROR_CLASS_PERMISSION = "permission"
+	ERROR_CLASS_TRANSIENT = "transient"
+	ERROR_CLASS_API = "api"
+	ERROR_CLASS_STORAGE = "storage"
+	ERROR_CLASS_INTERNAL = "internal"
+)
+
+// syncErrorRecord is one failed node or write of a sync run.
+type syncErrorRecord struct {
+	SessionID string `json:"session_id" bson:"session_id"`
+	Tenant string `json:"tenant" bson:"tenant"`
+	Sync string `json:"sync" bson:"sync"`
+	Node string `json:"node" bson:"node"`
+	Timestamp time.Time `json:"timestamp" bson:"timestamp"`
+	Attempt int `json:"attempt" bson:"attempt"`
+	Class string `json:"class" bson:"class"`
+	Error string `json:"error" bson:"error"`
+	ExpiresAt time.Time `json:"expires_at" bson:"expires_at"`
+}
+
+// errorSink stores sync error records for later inspection. Every sink drops
+// records once they are older than its TTL.
+type errorSink interface {
+	Write(ctx context.Context, records []syncErrorRecord) error
+	Close() error
+}
+
+func classifyError(err error) string {
+	var apiErr *APIError
+	switch {
+	case isAuthError(err):
+		return ERROR_CLASS_AUTH
+	case isPermissionError(err):
+		return ERROR_CLASS_PERMISSION
+	case isTransientError(err):
+		return ERROR_CLASS_TRANSIENT
+	case errors.As(err, &apiErr):
+		return ERROR_CLASS_API
+	default:
+		return ERROR_CLASS_INTERNAL
+	}
+}
+
+func newSyncErrorRecord(syncType string, nodeErr NodeError, ttl time.Duration) syncErrorRecord {
+	now := time.Now()
+	class := nodeErr.Class
+	if class == "" {
+		class = classifyError(nodeErr.Err)
+	}
+	return syncErrorRecord{
+		SessionID: sessionID,
+		Tenant: tenant,
+		Sync: syncType,
+		Node: nodeErr.Node,
+		Timestamp: now,
+		Attempt: max(nodeErr.Attempt, 1),
+		Class: class,
+		Error: nodeErr.Err.Error(),
+		ExpiresAt: now.Add(ttl),
+	}
+}
+
+// errorSinkKind returns ERROR_SINK, or the default: redis, except with the
+// sqlite backend and no Redis, which is meant to run without any server.
+func errorSinkKind() string {
+	if kind := os.Getenv("ERROR_SINK"); kind != "" {
+		return kind
+	}
+	if os.Getenv("STORAGE_BACKEND") == STORAGE_SQLITE && !redisConfigured() {
+		return ERROR_SINK_NDJSON
+	}
+	return ERROR_SINK_REDIS
+}
+
+// errorSinkUsesRedis reports whether the redis sink is selected.
+func errorSinkUsesRedis() bool {
+	return errorSinkKind() == ERROR_SINK_REDIS
+}
+
+// newErrorSink opens the sink selected by errorSinkKind.
+// Records the sink fails to store are written to the NDJSON sink instead, so
+// an outage of Redis or Mongo does not lose them.
+func newErrorSink(syncType string) (errorSink, time.Duration, error) {
+	ttl := ERROR_SINK_DEFAULT_TTL
+	if v := os.Getenv("ERROR_SINK_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, 0, fmt.Errorf("ERROR_SINK_TTL must be a duration: %v", err)
+		}
+		// Records would expire as soon as they are written, and the NDJSON
+		// sink would delete all of its earlier files.
+		if d <= 0 {
+			return nil, 0, fmt.Errorf("ERROR_SINK_TTL must be positive, got %s", v)
+		}
+		ttl = d
+	}
+	dir := os.Getenv("ERROR_SINK_DIR")
+	if dir == "" {
+		dir = DIRECTORY_DEFAULT_LOGS
+	}
+	ndjson, err := newNDJSONErrorSink(dir, ttl)
+	if err != nil {
+		return nil, 0, err
+	}
+	kind := errorSinkKind()
+	var primary errorSink
+	switch kind {
+	case ERROR_SINK_REDIS:
+		if redisClient == nil {
+			return nil, 0, fmt.Errorf("ERROR_SINK=%s needs Redis; set ERROR_SINK=%s to run without it", ERROR_SINK_REDIS, ERROR_SINK_NDJSON)
+		}
+		primary = &redisErrorSink{
+			key: redisKey(fmt.Sprintf("%s:errors:%s:sync", sessionID, syncType)),
+			ttl: ttl,
+		}
+	case ERROR_SINK_MONGO:
+		if mongoClient == nil {
+			return nil, 0, fmt.Errorf("ERROR_SINK=%s needs the %s storage backend", ERROR_SINK_MONGO, STORAGE_MONGO)
+		}
+		primary = newMongoErrorSink(mongoClient.Database(mongoDatabase))
+	case ERROR_SINK_NDJSON:
+		return ndjson, ttl, nil
+	default:
+		return nil, 0, fmt.Errorf("Unknown error sink %q (expected %s, %s or %s)",
+			kind, ERROR_SINK_REDIS, ERROR_SINK_MONGO, ERROR_SINK_NDJSON)
+	}
+	return &fallbackErrorSink{
+		primary: primary,
+		fallback: ndjson,
+	}, ttl, nil
+}
+
+type fallbackErrorSink struct {
+	primary errorSink
+	fallback errorSink
+}
+
+func (s *fallbackErrorSink) Write(ctx context.Context, records []syncErrorRecord) error {
+	err := s.primary.Write(ctx, records)
+	if err == nil {
+		return nil
+	}
+	slog.WarnContext(ctx, "Failed to write sync errors, writing them to the local file instead",
+		"count", len(records),
+		"error", err,
+		)
+	return s.fallback.Write(ctx, records)
+}
+
+func (s *fallbackErrorSink) Close() error {
+	return errors.Join(s.primary.Close(), s.fallback.Close())
+}
+
+type redisErrorSink struct {
+	key string
+	ttl time.Duration
+}
+
+func (s *redisErrorSink) Write(ctx context.Context, records []syncErrorRecord) error {
+	values := make([]any, 0, len(records))
+	for _, r := range records {
+		b, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		values = append(values, b)
+	}
+	pipe := redisClient.TxPipeline()
+	pipe.RPush(ctx, s.key, values...)
+	pipe.Expire(ctx, s.key, s.ttl)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (s *redisErrorSink) Close() error {
+	return nil
+}
+
+type mongoErrorSink struct {
+	collection *mongo.Collection
+}
+
+// newMongoErrorSink writes to sync_errors, whose TTL index on expires_at is
+// created by migration 8.
+func newMongoErrorSink(db *mongo.Database) *mongoErrorSink {
+	return &mongoErrorSink{
+		collection: db.Collection(MONGO_COLLECTION_SYNC_ERRORS),
+	}
+}
+
+func (s *mongoErrorSink) Write(ctx context.Context, records []syncErrorRecord) error {
+	docs := make([]any, 0, len(records))
+	for _, r := range records {
+		docs = append(docs, r)
+	}
+	_, err := s.collection.InsertMany(ctx, docs)
+	return err
+}
+
+func (s *mongoErrorSink) Close() error {
+	return nil
+}
+
+// ndjsonErrorSink appends records to <dir>/sync-errors-<date>.ndjson and
+// removes files older than the TTL when it is opened.
+type ndjsonErrorSink struct {
+	dir string
+	mu sync.Mutex
+	file *os.File
+}
+
+func newNDJSONErrorSink(dir string, ttl time.Duration) (*ndjsonErrorSink, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("Failed to create error sink directory: %w", err)
+	}
+	matches, _ := filepath.Glob(filepath.Join(dir, "sync-errors-*.ndjson"))
+	cutoff := time.Now().Add(-ttl)
+	for _, name := range matches {
+		if info, err := os.Stat(name); err == nil && info.ModTime().Before(cutoff) {
+			os.Remove(name)
+		}
+	}
+	return &ndjsonErrorSink{
+		dir: dir,
+	}, nil
+}
+
+func (s *ndjsonErrorSink) Write(ctx context.Context, records []syncErrorRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		date := time.Now().In(time.Local).Format(time.DateOnly)
+		name := filepath.Join(s.dir, fmt.Sprintf("sync-errors-%s.ndjson", date))
+		f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("Failed to open error sink file: %w", err)
+		}
+		s.file = f
+	}
+	enc := json.NewEncoder(s.file)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *ndjsonErrorSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	return s.file.Close()
+}
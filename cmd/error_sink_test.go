@@ -0,0 +1,30 @@
+package cmd
+
+import "testing"
+
+func TestErrorSinkKind(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		sink string
+		backend string
+		redisHost string
+		want string
+	}{
+		{name: "mongo", want: ERROR_SINK_REDIS},
+		{name: "sqlite without redis", backend: STORAGE_SQLITE, want: ERROR_SINK_NDJSON},
+		{name: "sqlite with redis", backend: STORAGE_SQLITE, redisHost: "localhost", want: ERROR_SINK_REDIS},
+		{name: "explicit", sink: ERROR_SINK_REDIS, backend: STORAGE_SQLITE, want: ERROR_SINK_REDIS},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("ERROR_SINK", tc.sink)
+			t.Setenv("STORAGE_BACKEND", tc.backend)
+			t.Setenv("REDIS_HOST", tc.redisHost)
+			for _, env := range []string{"REDIS_URL", "REDIS_URL_FILE", "REDIS_ADDRS"} {
+				t.Setenv(env, "")
+			}
+			if got := errorSinkKind(); got != tc.want {
+				t.Errorf("errorSinkKind() = %q, expected %q", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+)
+
+// fanoutHandler sends each record to every handler that is enabled for its
+// level, so the log file and stderr can use different levels and formats.
+type fanoutHandler struct {
+	handlers []slog.Handler
+}
+
+func (h *fanoutHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, handler := range h.handlers {
+		if handler.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *fanoutHandler) Handle(ctx context.Context, r slog.Record) error {
+	var err error
+	for _, handler := range h.handlers {
+		if handler.Enabled(ctx, r.Level) {
+			err = errors.Join(err, handler.Handle(ctx, r.Clone()))
+		}
+	}
+	return err
+}
+
+func (h *fanoutHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handlers := make([]slog.Handler, len(h.handlers))
+	for i, handler := range h.handlers {
+		handlers[i] = handler.WithAttrs(attrs)
+	}
+	return &fanoutHandler{handlers: handlers}
+}
+
+func (h *fanoutHandler) WithGroup(name string) slog.Handler {
+	handlers := make([]slog.Handler, len(h.handlers))
+	for i, handler := range h.handlers {
+		handlers[i] = handler.WithGroup(name)
+	}
+	return &fanoutHandler{handlers: handlers}
+}
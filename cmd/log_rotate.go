@@ -0,0 +1,198 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LOG_ROTATE_RETRY_DELAY is how long a failed rotation waits before the next
+// attempt.
+const LOG_ROTATE_RETRY_DELAY = time.Minute
+
+// rotatingWriter is the log file writer. Without an explicit path it writes
+// to <dir>/debug-<date>.log and starts a new file when the date changes, like
+// the daily files written before rotation existed. With an explicit path the
+// file is renamed to <name>-<timestamp><ext> when it rotates. In both modes a
+// file is also rotated once it grows past maxSize, and rotated files older
+// than retention or beyond the newest maxFiles are removed.
+type rotatingWriter struct {
+	dir string
+	path string
+	maxSize int64
+	maxAge time.Duration
+	retention time.Duration
+	maxFiles int
+
+	mu sync.Mutex
+	file *os.File
+	size int64
+	openedAt time.Time
+	retryAt time.Time
+}
+
+func newRotatingWriter(
+	dir string,
+	path string,
+	maxSize int64,
+	maxAge time.Duration,
+	retention time.Duration,
+	maxFiles int,
+) (*rotatingWriter, error) {
+	w := &rotatingWriter{
+		dir: dir,
+		path: path,
+		maxSize: maxSize,
+		maxAge: maxAge,
+		retention: retention,
+		maxFiles: maxFiles,
+	}
+	if path != "" {
+		w.dir = filepath.Dir(path)
+	}
+	if err := os.MkdirAll(w.dir, 0755); err != nil {
+		return nil, fmt.Errorf("Failed to create log directory: %w", err)
+	}
+	if err := w.open(time.Now()); err != nil {
+		return nil, err
+	}
+	w.prune()
+	return w, nil
+}
+
+func (w *rotatingWriter) currentPath(now time.Time) string {
+	if w.path != "" {
+		return w.path
+	}
+	date := now.In(time.Local).Format(time.DateOnly)
+	return filepath.Join(w.dir, fmt.Sprintf("debug-%s.log", date))
+}
+
+func (w *rotatingWriter) open(now time.Time) error {
+	name := w.currentPath(now)
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("Failed to open log file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("Failed to stat log file: %w", err)
+	}
+	w.file = f
+	w.size = info.Size()
+	w.openedAt = now
+	return nil
+}
+
+func (w *rotatingWriter) shouldRotate(now time.Time, n int) bool {
+	if w.maxSize > 0 && w.size > 0 && w.size+int64(n) > w.maxSize {
+		return true
+	}
+	if w.path == "" {
+		return w.currentPath(now) != w.file.Name()
+	}
+	return w.maxAge > 0 && now.Sub(w.openedAt) >= w.maxAge
+}
+
+// rotate moves the current file out of the way if the next file would have
+// the same name, opens the next one and only then closes the current one, so
+// that a failed rotation leaves the current file open for the next Write.
+func (w *rotatingWriter) rotate(now time.Time) error {
+	previous := w.file
+	current := previous.Name()
+	rotated := ""
+	if w.currentPath(now) == current {
+		ext := filepath.Ext(current)
+		stem := strings.TrimSuffix(current, ext)
+		rotated = fmt.Sprintf("%s-%s%s", stem, now.In(time.Local).Format("20060102T150405.000"), ext)
+		if err := os.Rename(current, rotated); err != nil {
+			return err
+		}
+	}
+	if err := w.open(now); err != nil {
+		if rotated != "" {
+			os.Rename(rotated, current)
+		}
+		return err
+	}
+	previous.Close()
+	w.prune()
+	return nil
+}
+
+func (w *rotatingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	now := time.Now()
+	if now.After(w.retryAt) && w.shouldRotate(now, len(p)) {
+		if err := w.rotate(now); err != nil {
+			// Keep logging to the current file rather than losing records,
+			// and try again later. Logging through slog would deadlock.
+			w.retryAt = now.Add(LOG_ROTATE_RETRY_DELAY)
+			fmt.Fprintf(os.Stderr, "Failed to rotate log file, retrying in %s: %v\n", LOG_ROTATE_RETRY_DELAY, err)
+		}
+	}
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *rotatingWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.file.Close()
+}
+
+// prune removes old log files, never touching the one being written.
+func (w *rotatingWriter) prune() {
+	if w.retention <= 0 && w.maxFiles <= 0 {
+		return
+	}
+	pattern := filepath.Join(w.dir, "debug-*.log")
+	if w.path != "" {
+		ext := filepath.Ext(w.path)
+		pattern = strings.TrimSuffix(w.path, ext) + "-*" + ext
+	}
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return
+	}
+	type logFileInfo struct {
+		name string
+		modTime time.Time
+	}
+	var files []logFileInfo
+	for _, name := range matches {
+		if name == w.file.Name() {
+			continue
+		}
+		info, err := os.Stat(name)
+		if err != nil || info.IsDir() {
+			continue
+		}
+		files = append(files, logFileInfo{name, info.ModTime()})
+	}
+	slices.SortFunc(files, func(a, b logFileInfo) int {
+		return b.modTime.Compare(a.modTime)
+	})
+	cutoff := time.Now().Add(-w.retention)
+	var removeErr error
+	for i, f := range files {
+		expired := w.retention > 0 && f.modTime.Before(cutoff)
+		// The active file counts towards maxFiles.
+		excess := w.maxFiles > 0 && i+1 >= w.maxFiles
+		if expired || excess {
+			removeErr = errors.Join(removeErr, os.Remove(f.name))
+		}
+	}
+	// Logging through slog here would re-enter Write and deadlock on w.mu.
+	if removeErr != nil {
+		fmt.Fprintf(os.Stderr, "Failed to remove old log files: %v\n", removeErr)
+	}
+}
@@ -0,0 +1,181 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	METRICS_NAMESPACE = "abdsa"
+	API_ENDPOINT_OTHER = "other"
+)
+
+var (
+	apiRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name: "api_requests_total",
+		Help: "DirectCloud API requests by endpoint and HTTP status.",
+	}, []string{"endpoint", "status"})
+	apiRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name: "api_request_duration_seconds",
+		Help: "DirectCloud API request latency by endpoint and HTTP status.",
+		Buckets: prometheus.ExponentialBuckets(0.05, 2, 10),
+	}, []string{"endpoint", "status"})
+	rateLimiterWait = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name: "rate_limiter_wait_seconds",
+		Help: "Time spent waiting for the API rate limiter.",
+		Buckets: prometheus.ExponentialBuckets(0.001, 4, 8),
+	})
+	syncQueueDepth = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name: "sync_queue_depth",
+		Help: "Items waiting in the sync queues.",
+	}, []string{"queue"})
+	storageBulkWritesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name: "storage_bulk_writes_total",
+		Help: "Storage bulk writes by backend and collection.",
+	}, []string{"backend", "collection"})
+	storageBulkWriteErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name: "storage_bulk_write_errors_total",
+		Help: "Failed storage bulk writes by backend and collection.",
+	}, []string{"backend", "collection"})
+	storageBulkWriteDocumentsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name: "storage_bulk_write_documents_total",
+		Help: "Documents affected by storage bulk writes by backend, collection and result.",
+	}, []string{"backend", "collection", "result"})
+	syncRunsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name: "sync_runs_total",
+		Help: "Finished sync runs by sync type and result.",
+	}, []string{"sync", "result"})
+	syncLastSuccess = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name: "sync_last_success_timestamp_seconds",
+		Help: "Unix time of the last successful sync by sync type.",
+	}, []string{"sync"})
+)
+
+var metricsServer *http.Server
+
+func initMetricsServer(ctx context.Context, addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", promhttp.Handler())
+	metricsServer = &http.Server{
+		Addr: addr,
+		Handler: mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		if err := metricsServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.ErrorContext(ctx, "Metrics server failed",
+				"addr", addr,
+				"error", err,
+				)
+		}
+	}()
+	slog.DebugContext(ctx, "Metrics server listening",
+		"addr", addr,
+		)
+}
+
+func closeMetricsServer(ctx context.Context) error {
+	shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+	return metricsServer.Shutdown(shutdownCtx)
+}
+
+// apiEndpointLabel reduces a request path such as
+// /openapp/m1/sharedboxes/lists/<node> to "sharedboxes/lists" so that node
+// IDs do not end up in label values. Paths outside /openapp/ and /openapi/,
+// such as endpoints overridden by the user, are all labelled "other", as
+// there is no telling which of their elements are IDs.
+func apiEndpointLabel(path string) string {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	for i, part := range parts {
+		if part != "openapp" && part != "openapi" {
+			continue
+		}
+		rest := parts[i+1:]
+		switch {
+		case len(rest) >= 3:
+			return rest[1] + "/" + rest[2]
+		case len(rest) == 2:
+			// /openapi/jauth/token
+			return rest[0] + "/" + rest[1]
+		}
+		return API_ENDPOINT_OTHER
+	}
+	return API_ENDPOINT_OTHER
+}
+
+// instrumentedTransport records request counts, latency and a client span for
+// every API call.
+type instrumentedTransport struct {
+	next http.RoundTripper
+}
+
+func (t *instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	endpoint := apiEndpointLabel(req.URL.Path)
+	ctx, span := tracer.Start(req.Context(), "directcloud "+endpoint,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.request.method", req.Method),
+			attribute.String("url.path", req.URL.Path),
+			),
+		)
+	defer span.End()
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req.WithContext(ctx))
+	status := "error"
+	if err == nil {
+		status = strconv.Itoa(resp.StatusCode)
+		span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+		if resp.StatusCode >= http.StatusBadRequest {
+			span.SetStatus(codes.Error, resp.Status)
+		}
+	} else {
+		recordSpanError(span, err)
+	}
+	apiRequestsTotal.WithLabelValues(endpoint, status).Inc()
+	apiRequestDuration.WithLabelValues(endpoint, status).Observe(time.Since(start).Seconds())
+	return resp, err
+}
+
+// observeBulkWrite records a bulk write to the storage backend in use.
+func observeBulkWrite(collection string, inserted, modified, upserted, matched int64, err error) {
+	storageBulkWritesTotal.WithLabelValues(storageBackend, collection).Inc()
+	if err != nil {
+		storageBulkWriteErrorsTotal.WithLabelValues(storageBackend, collection).Inc()
+		return
+	}
+	storageBulkWriteDocumentsTotal.WithLabelValues(storageBackend, collection, "inserted").Add(float64(inserted))
+	storageBulkWriteDocumentsTotal.WithLabelValues(storageBackend, collection, "modified").Add(float64(modified))
+	storageBulkWriteDocumentsTotal.WithLabelValues(storageBackend, collection, "upserted").Add(float64(upserted))
+	storageBulkWriteDocumentsTotal.WithLabelValues(storageBackend, collection, "matched").Add(float64(matched))
+}
+
+func observeSyncRun(syncType string, err error) {
+	if err != nil {
+		syncRunsTotal.WithLabelValues(syncType, "failure").Inc()
+		return
+	}
+	syncRunsTotal.WithLabelValues(syncType, "success").Inc()
+	syncLastSuccess.WithLabelValues(syncType).SetToCurrentTime()
+}
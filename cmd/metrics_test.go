@@ -0,0 +1,27 @@
+package cmd
+
+import "testing"
+
+func TestApiEndpointLabel(t *testing.T) {
+	for _, tc := range []struct {
+		path string
+		want string
+	}{
+		{path: "/openapp/m1/sharedboxes/lists/", want: "sharedboxes/lists"},
+		{path: "/openapp/m1/sharedboxes/lists/AbC123", want: "sharedboxes/lists"},
+		{path: "/openapp/m1/users/lists/", want: "users/lists"},
+		{path: "/openapp/v1/files/index/AbC123", want: "files/index"},
+		{path: "/openapi/jauth/token", want: "jauth/token"},
+		// A base URL with a path prefix, e.g. behind a proxy.
+		{path: "/directcloud/openapp/m1/sharedboxes/lists/AbC123", want: "sharedboxes/lists"},
+		// Overridden endpoints may put IDs anywhere.
+		{path: "/files/AbC123", want: API_ENDPOINT_OTHER},
+		{path: "/mock/files/lists/AbC123/x", want: API_ENDPOINT_OTHER},
+		{path: "/openapp", want: API_ENDPOINT_OTHER},
+		{path: "/", want: API_ENDPOINT_OTHER},
+	} {
+		if got := apiEndpointLabel(tc.path); got != tc.want {
+			t.Errorf("apiEndpointLabel(%q) = %q, expected %q", tc.path, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,514 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	MONGO_COLLECTION_SCHEMA_MIGRATIONS = "schema_migrations"
+	// Document schema versions written by this build. Bump them together
+	// with a migration that backfills the new fields.
+	SHAREDBOX_SCHEMA_VERSION = 3
+	USER_SCHEMA_VERSION = 1
+	FILE_SCHEMA_VERSION = 1
+	MIGRATION_BATCH_SIZE = 500
+)
+
+// migration is one versioned change to the Mongo mirror. Versions are
+// applied in ascending order and recorded in schema_migrations. Down is nil
+// for migrations that cannot be reverted.
+type migration struct {
+	Version int
+	Name string
+	Up func(ctx context.Context, db *mongo.Database) error
+	Down func(ctx context.Context, db *mongo.Database) error
+}
+
+// appliedMigration is a document of the schema_migrations collection.
+type appliedMigration struct {
+	Version int `bson:"_id"`
+	Name string `bson:"name"`
+	AppliedAt time.Time `bson:"applied_at"`
+}
+
+// mongoMigrations must only ever be appended to.
+var mongoMigrations = []migration{
+	{
+		Version: 1,
+		Name: "sharedboxes_tenant_node_index",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			collection := db.Collection(MONGO_COLLECTION_SHAREDBOXES)
+			if err := dropIndexIfExists(ctx, collection, "idx_node_unique"); err != nil {
+				return err
+			}
+			return createIndex(ctx, collection, "idx_tenant_node_unique", true,
+				bson.D{{Key: "tenant", Value: 1}, {Key: "item.node", Value: 1}})
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			collection := db.Collection(MONGO_COLLECTION_SHAREDBOXES)
+			if err := dropIndexIfExists(ctx, collection, "idx_tenant_node_unique"); err != nil {
+				return err
+			}
+			return createIndex(ctx, collection, "idx_node_unique", true,
+				bson.D{{Key: "item.node", Value: 1}})
+		},
+	},
+	{
+		Version: 2,
+		Name: "users_tenant_user_seq_index",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			collection := db.Collection(MONGO_COLLECTION_USERS)
+			if err := dropIndexIfExists(ctx, collection, "idx_user_seq_unique"); err != nil {
+				return err
+			}
+			return createIndex(ctx, collection, "idx_tenant_user_seq_unique", true,
+				bson.D{{Key: "tenant", Value: 1}, {Key: "user_seq", Value: 1}})
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			collection := db.Collection(MONGO_COLLECTION_USERS)
+			if err := dropIndexIfExists(ctx, collection, "idx_tenant_user_seq_unique"); err != nil {
+				return err
+			}
+			return createIndex(ctx, collection, "idx_user_seq_unique", true,
+				bson.D{{Key: "user_seq", Value: 1}})
+		},
+	},
+	{
+		// Documents written before multi-tenant support have no tenant. They
+		// can only belong to the tenant the mirror was synced for, which is
+		// the one migrate runs with.
+		Version: 3,
+		Name: "backfill_tenant",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			for _, name := range []string{MONGO_COLLECTION_SHAREDBOXES, MONGO_COLLECTION_USERS} {
+				if err := backfillField(ctx, db.Collection(name), "tenant", tenant); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		// Going back to documents without a tenant only works while the
+		// mirror holds the tenant migrate runs with and no other.
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			for _, name := range []string{MONGO_COLLECTION_SHAREDBOXES, MONGO_COLLECTION_USERS} {
+				collection := db.Collection(name)
+				others, err := collection.CountDocuments(ctx, bson.M{"tenant": bson.M{"$ne": tenant}})
+				if err != nil {
+					return fmt.Errorf("Failed to count documents of other tenants in %s: %w", name, err)
+				}
+				if others > 0 {
+					return fmt.Errorf("%s holds %d documents of tenants other than %s", name, others, tenant)
+				}
+			}
+			for _, name := range []string{MONGO_COLLECTION_SHAREDBOXES, MONGO_COLLECTION_USERS} {
+				_, err := db.Collection(name).UpdateMany(ctx,
+					bson.M{},
+					bson.M{"$unset": bson.M{"tenant": ""}},
+					)
+				if err != nil {
+					return fmt.Errorf("Failed to unset tenant on %s: %w", name, err)
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version: 4,
+		Name: "backfill_schema_version",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			if err := backfillField(ctx, db.Collection(MONGO_COLLECTION_SHAREDBOXES), "schema_version", 1); err != nil {
+				return err
+			}
+			return backfillField(ctx, db.Collection(MONGO_COLLECTION_USERS), "schema_version", 1)
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			for _, name := range []string{MONGO_COLLECTION_SHAREDBOXES, MONGO_COLLECTION_USERS} {
+				_, err := db.Collection(name).UpdateMany(ctx,
+					bson.M{},
+					bson.M{"$unset": bson.M{"schema_version": ""}},
+					)
+				if err != nil {
+					return fmt.Errorf("Failed to unset schema_version on %s: %w", name, err)
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version: 5,
+		Name: "sharedboxes_search_ngrams",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			collection := db.Collection(MONGO_COLLECTION_SHAREDBOXES)
+			err := backfillDocuments(ctx, collection,
+				bson.M{"schema_version": bson.M{"$lt": 2}},
+				func(item SharedBoxListItemWithParent) bson.M {
+					return bson.M{
+						"search_ngrams": sharedboxSearchNgrams(item),
+						"schema_version": 2,
+					}
+				})
+			if err != nil {
+				return err
+			}
+			return createIndex(ctx, collection, "idx_tenant_search_ngrams", false,
+				bson.D{{Key: "tenant", Value: 1}, {Key: "search_ngrams", Value: 1}})
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			collection := db.Collection(MONGO_COLLECTION_SHAREDBOXES)
+			if err := dropIndexIfExists(ctx, collection, "idx_tenant_search_ngrams"); err != nil {
+				return err
+			}
+			_, err := collection.UpdateMany(ctx,
+				bson.M{},
+				bson.M{
+					"$unset": bson.M{"search_ngrams": ""},
+					"$set": bson.M{"schema_version": 1},
+				},
+				)
+			if err != nil {
+				return fmt.Errorf("Failed to unset search_ngrams: %w", err)
+			}
+			return nil
+		},
+	},
+	{
+		Version: 6,
+		Name: "sharedboxes_drive_path_index",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			return createIndex(ctx, db.Collection(MONGO_COLLECTION_SHAREDBOXES), "idx_tenant_drive_path", false,
+				bson.D{{Key: "tenant", Value: 1}, {Key: "item.drive_path", Value: 1}})
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			return dropIndexIfExists(ctx, db.Collection(MONGO_COLLECTION_SHAREDBOXES), "idx_tenant_drive_path")
+		},
+	},
+	{
+		Version: 7,
+		Name: "files_indexes",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			collection := db.Collection(MONGO_COLLECTION_FILES)
+			if err := createIndex(ctx, collection, "idx_tenant_file_seq_unique", true,
+				bson.D{{Key: "tenant", Value: 1}, {Key: "file_seq", Value: 1}}); err != nil {
+				return err
+			}
+			return createIndex(ctx, collection, "idx_tenant_parent_node", false,
+				bson.D{{Key: "tenant", Value: 1}, {Key: "parent_node", Value: 1}})
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			collection := db.Collection(MONGO_COLLECTION_FILES)
+			if err := dropIndexIfExists(ctx, collection, "idx_tenant_parent_node"); err != nil {
+				return err
+			}
+			return dropIndexIfExists(ctx, collection, "idx_tenant_file_seq_unique")
+		},
+	},
+	{
+		// Earlier builds created this index when the mongo error sink was
+		// opened; CreateOne is a no-op when it already exists.
+		Version: 8,
+		Name: "sync_errors_ttl_index",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			collection := db.Collection(MONGO_COLLECTION_SYNC_ERRORS)
+			_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys: bson.D{{Key: "expires_at", Value: 1}},
+				Options: options.Index().
+					SetExpireAfterSeconds(0).
+					SetName("idx_expires_at_ttl"),
+			})
+			if err != nil {
+				return fmt.Errorf("Failed to create TTL index on %s: %w", collection.Name(), err)
+			}
+			return nil
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			return dropIndexIfExists(ctx, db.Collection(MONGO_COLLECTION_SYNC_ERRORS), "idx_expires_at_ttl")
+		},
+	},
+	{
+		// Paths are looked up cleaned, as stored paths may differ from what
+		// the user types in Unicode normalization or a trailing slash.
+		Version: 9,
+		Name: "sharedboxes_drive_path_key",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			collection := db.Collection(MONGO_COLLECTION_SHAREDBOXES)
+			err := backfillDocuments(ctx, collection,
+				bson.M{"schema_version": bson.M{"$lt": 3}},
+				func(item SharedBoxListItemWithParent) bson.M {
+					return bson.M{
+						"drive_path_key": cleanDrivePath(item.Item.DrivePath),
+						"schema_version": 3,
+					}
+				})
+			if err != nil {
+				return err
+			}
+			return createIndex(ctx, collection, "idx_tenant_drive_path_key", false,
+				bson.D{{Key: "tenant", Value: 1}, {Key: "drive_path_key", Value: 1}})
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			collection := db.Collection(MONGO_COLLECTION_SHAREDBOXES)
+			if err := dropIndexIfExists(ctx, collection, "idx_tenant_drive_path_key"); err != nil {
+				return err
+			}
+			_, err := collection.UpdateMany(ctx,
+				bson.M{},
+				bson.M{
+					"$unset": bson.M{"drive_path_key": ""},
+					"$set": bson.M{"schema_version": 2},
+				},
+				)
+			if err != nil {
+				return fmt.Errorf("Failed to unset drive_path_key: %w", err)
+			}
+			return nil
+		},
+	},
+}
+
+func createIndex(ctx context.Context, collection *mongo.Collection, name string, unique bool, keys bson.D) error {
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: keys,
+		Options: options.Index().
+			SetUnique(unique).
+			SetName(name),
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to create index %s on %s: %w", name, collection.Name(), err)
+	}
+	return nil
+}
+
+// dropIndexIfExists drops name, ignoring indexes or collections that do not
+// exist so that migrations can be re-run.
+func dropIndexIfExists(ctx context.Context, collection *mongo.Collection, name string) error {
+	_, err := collection.Indexes().DropOne(ctx, name)
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) && (cmdErr.Name == "IndexNotFound" || cmdErr.Name == "NamespaceNotFound") {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to drop index %s on %s: %w", name, collection.Name(), err)
+	}
+	return nil
+}
+
+// backfillField sets field to value on every document that lacks it.
+func backfillField(ctx context.Context, collection *mongo.Collection, field string, value any) error {
+	result, err := collection.UpdateMany(ctx,
+		bson.M{field: bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{field: value}},
+		)
+	if err != nil {
+		return fmt.Errorf("Failed to backfill %s on %s: %w", field, collection.Name(), err)
+	}
+	slog.InfoContext(ctx, "Backfilled field",
+		"collection", collection.Name(),
+		"field", field,
+		"modified", result.ModifiedCount,
+		)
+	return nil
+}
+
+// backfillDocuments sets the fields computed by fields on every sharedbox
+// matching filter, for backfills that cannot be written as a single update.
+func backfillDocuments(
+	ctx context.Context,
+	collection *mongo.Collection,
+	filter bson.M,
+	fields func(item SharedBoxListItemWithParent) bson.M,
+) error {
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		return fmt.Errorf("Failed to read %s for backfill: %w", collection.Name(), err)
+	}
+	defer cursor.Close(ctx)
+	var models []mongo.WriteModel
+	modified := int64(0)
+	flush := func() error {
+		if len(models) == 0 {
+			return nil
+		}
+		result, err := collection.BulkWrite(ctx, models)
+		if err != nil {
+			return fmt.Errorf("Failed to backfill %s: %w", collection.Name(), err)
+		}
+		modified += result.ModifiedCount
+		models = models[:0]
+		return nil
+	}
+	for cursor.Next(ctx) {
+		var doc struct {
+			ID any `bson:"_id"`
+			SharedBoxListItemWithParent `bson:",inline"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			return fmt.Errorf("Failed to decode %s document: %w", collection.Name(), err)
+		}
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": doc.ID}).
+			SetUpdate(bson.M{"$set": fields(doc.SharedBoxListItemWithParent)}))
+		if len(models) >= MIGRATION_BATCH_SIZE {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return fmt.Errorf("Failed to read %s for backfill: %w", collection.Name(), err)
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "Backfilled documents",
+		"collection", collection.Name(),
+		"modified", modified,
+		)
+	return nil
+}
+
+// renameCollectionMigration is a migration that renames the collection from
+// to to, and back on the way down.
+func renameCollectionMigration(version int, name string, from string, to string) migration {
+	return migration{
+		Version: version,
+		Name: name,
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			return renameCollection(ctx, db, from, to)
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			return renameCollection(ctx, db, to, from)
+		},
+	}
+}
+
+// renameCollection renames from to to within db. A missing source collection
+// is not an error, so a migration using it can be re-run.
+func renameCollection(ctx context.Context, db *mongo.Database, from string, to string) error {
+	err := db.Client().Database("admin").RunCommand(ctx, bson.D{
+		{Key: "renameCollection", Value: db.Name() + "." + from},
+		{Key: "to", Value: db.Name() + "." + to},
+	}).Err()
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) && cmdErr.Name == "NamespaceNotFound" {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to rename collection %s to %s: %w", from, to, err)
+	}
+	return nil
+}
+
+func appliedMigrations(ctx context.Context, db *mongo.Database) (map[int]appliedMigration, error) {
+	cursor, err := db.Collection(MONGO_COLLECTION_SCHEMA_MIGRATIONS).Find(ctx, bson.M{})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read %s: %w", MONGO_COLLECTION_SCHEMA_MIGRATIONS, err)
+	}
+	var docs []appliedMigration
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, fmt.Errorf("Failed to read %s: %w", MONGO_COLLECTION_SCHEMA_MIGRATIONS, err)
+	}
+	applied := make(map[int]appliedMigration, len(docs))
+	for _, doc := range docs {
+		applied[doc.Version] = doc
+	}
+	return applied, nil
+}
+
+// pendingMigrations returns the migrations not yet applied, in order.
+func pendingMigrations(ctx context.Context, db *mongo.Database) ([]migration, error) {
+	applied, err := appliedMigrations(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	var pending []migration
+	for _, m := range mongoMigrations {
+		if _, ok := applied[m.Version]; !ok {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// migrateUp applies pending migrations up to and including target, or all of
+// them when target is 0.
+func migrateUp(ctx context.Context, db *mongo.Database, target int) (int, error) {
+	pending, err := pendingMigrations(ctx, db)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, m := range pending {
+		if target > 0 && m.Version > target {
+			break
+		}
+		slog.InfoContext(ctx, "Applying migration",
+			"version", m.Version,
+			"name", m.Name,
+			)
+		if err := m.Up(ctx, db); err != nil {
+			return count, fmt.Errorf("Migration %d %s failed: %w", m.Version, m.Name, err)
+		}
+		// The version is the _id, so a concurrent run applying the same
+		// migration fails here instead of recording it twice.
+		_, err := db.Collection(MONGO_COLLECTION_SCHEMA_MIGRATIONS).InsertOne(ctx, appliedMigration{
+			Version: m.Version,
+			Name: m.Name,
+			AppliedAt: time.Now().UTC(),
+		})
+		if err != nil {
+			return count, fmt.Errorf("Failed to record migration %d %s: %w", m.Version, m.Name, err)
+		}
+		count++
+	}
+	return count, nil
+}
+
+// migrateDown reverts applied migrations newer than target, newest first.
+func migrateDown(ctx context.Context, db *mongo.Database, target int) (int, error) {
+	applied, err := appliedMigrations(ctx, db)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, m := range slices.Backward(mongoMigrations) {
+		if m.Version <= target {
+			break
+		}
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		if m.Down == nil {
+			return count, fmt.Errorf("Migration %d %s cannot be reverted", m.Version, m.Name)
+		}
+		slog.InfoContext(ctx, "Reverting migration",
+			"version", m.Version,
+			"name", m.Name,
+			)
+		if err := m.Down(ctx, db); err != nil {
+			return count, fmt.Errorf("Reverting migration %d %s failed: %w", m.Version, m.Name, err)
+		}
+		_, err := db.Collection(MONGO_COLLECTION_SCHEMA_MIGRATIONS).DeleteOne(ctx, bson.M{"_id": m.Version})
+		if err != nil {
+			return count, fmt.Errorf("Failed to remove migration record %d %s: %w", m.Version, m.Name, err)
+		}
+		count++
+	}
+	return count, nil
+}
+
+// latestMigrationVersion is the version a fully migrated database is at.
+func latestMigrationVersion() int {
+	if len(mongoMigrations) == 0 {
+		return 0
+	}
+	return mongoMigrations[len(mongoMigrations)-1].Version
+}
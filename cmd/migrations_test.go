@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestMongoMigrationsOrdered(t *testing.T) {
+	names := map[string]bool{}
+	for i, m := range mongoMigrations {
+		if m.Version != i+1 {
+			t.Errorf("migration %d %s has version %d, expected %d", i, m.Name, m.Version, i+1)
+		}
+		if names[m.Name] {
+			t.Errorf("migration name %s is used twice", m.Name)
+		}
+		names[m.Name] = true
+		if m.Up == nil {
+			t.Errorf("migration %d %s has no Up", m.Version, m.Name)
+		}
+		if m.Down == nil {
+			t.Errorf("migration %d %s has no Down", m.Version, m.Name)
+		}
+	}
+	if got := latestMigrationVersion(); got != len(mongoMigrations) {
+		t.Errorf("latestMigrationVersion() = %d, expected %d", got, len(mongoMigrations))
+	}
+}
+
+// testMongoDatabase returns a scratch database on MONGO_TEST_URI, which is
+// dropped when the test ends. Tests using it are skipped without the variable.
+func testMongoDatabase(t *testing.T) *mongo.Database {
+	t.Helper()
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI is not set")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := client.Database(fmt.Sprintf("abdsa_test_%d", time.Now().UnixNano()))
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		db.Drop(ctx)
+		client.Disconnect(ctx)
+	})
+	return db
+}
+
+func TestRenameCollectionMigration(t *testing.T) {
+	db := testMongoDatabase(t)
+	ctx := context.Background()
+	if _, err := db.Collection("old_items").InsertOne(ctx, bson.M{"n": 1}); err != nil {
+		t.Fatal(err)
+	}
+	m := renameCollectionMigration(1, "rename_items", "old_items", "new_items")
+	collections := func() []string {
+		names, err := db.ListCollectionNames(ctx, bson.M{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		slices.Sort(names)
+		return names
+	}
+
+	if err := m.Up(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	if got := collections(); !slices.Equal(got, []string{"new_items"}) {
+		t.Fatalf("after Up: collections %v, expected [new_items]", got)
+	}
+	// Re-running finds no source collection, which is not an error.
+	if err := m.Up(ctx, db); err != nil {
+		t.Fatalf("re-running Up: %v", err)
+	}
+	if err := m.Down(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	if got := collections(); !slices.Equal(got, []string{"old_items"}) {
+		t.Fatalf("after Down: collections %v, expected [old_items]", got)
+	}
+}
@@ -0,0 +1,137 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// mockDirectCloud serves a synthetic sharedbox tree with the same response
+// shape as the DirectCloud admin API. Node IDs encode their position in the
+// tree ("3-0-7"), so no state is kept no matter how large the tree is.
+type mockDirectCloud struct {
+	Depth   int
+	Fanout  int
+	Latency time.Duration
+	// FilesPerNode is the number of files listed in every folder.
+	FilesPerNode int
+}
+
+func (m *mockDirectCloud) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /openapp/m1/sharedboxes/lists", m.sharedboxesList)
+	mux.HandleFunc("GET /openapp/m1/sharedboxes/lists/{node...}", m.sharedboxesList)
+	mux.HandleFunc("GET /openapp/m1/users/lists/", m.usersList)
+	mux.HandleFunc("GET "+DIRECTCLOUD_DEFAULT_FILES_LIST_PATH+"{node...}", m.filesList)
+	mux.HandleFunc("GET "+DIRECTCLOUD_DEFAULT_FILES_DOWNLOAD_PATH+"{node...}", m.fileDownload)
+	return mux
+}
+
+func (m *mockDirectCloud) Start() *httptest.Server {
+	return httptest.NewServer(m.Handler())
+}
+
+// NodeCount is the number of nodes below the root.
+func (m *mockDirectCloud) NodeCount() int {
+	total, level := 0, 1
+	for i := 0; i < m.Depth; i++ {
+		level *= m.Fanout
+		total += level
+	}
+	return total
+}
+
+func (m *mockDirectCloud) sharedboxesList(w http.ResponseWriter, r *http.Request) {
+	if m.Latency > 0 {
+		time.Sleep(m.Latency)
+	}
+	node := strings.Trim(r.PathValue("node"), "/")
+	depth := 0
+	if node != "" {
+		depth = strings.Count(node, "-") + 1
+	}
+	resp := SharedBoxListResponse{
+		Success: true,
+		Lists: []SharedBoxListItem{},
+	}
+	if depth < m.Depth {
+		for i := 0; i < m.Fanout; i++ {
+			child := fmt.Sprintf("%d", i)
+			if node != "" {
+				child = fmt.Sprintf("%s-%d", node, i)
+			}
+			resp.Lists = append(resp.Lists, SharedBoxListItem{
+				Name: "folder-" + child,
+				Node: child,
+				URL: "https://mock.invalid/" + child,
+				DrivePath: "/Shared/" + strings.ReplaceAll(child, "-", "/"),
+			})
+		}
+	}
+	resp.Total = len(resp.Lists)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (m *mockDirectCloud) usersList(w http.ResponseWriter, r *http.Request) {
+	if m.Latency > 0 {
+		time.Sleep(m.Latency)
+	}
+	resp := UserListResponse{
+		Success: true,
+		Lists: []UserListItem{},
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (m *mockDirectCloud) filesList(w http.ResponseWriter, r *http.Request) {
+	if m.Latency > 0 {
+		time.Sleep(m.Latency)
+	}
+	node := strings.Trim(r.PathValue("node"), "/")
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit <= 0 {
+		limit = DIRECTCLOUD_FILES_PAGE_SIZE
+	}
+	resp := FileListResponse{
+		Success: true,
+		Lists: []FileListItem{},
+	}
+	for i := offset; i < m.FilesPerNode && i < offset+limit; i++ {
+		resp.Lists = append(resp.Lists, FileListItem{
+			FileSeq: apiString(fmt.Sprintf("%s-f%d", node, i)),
+			Name: fmt.Sprintf("file-%d.txt", i),
+			Size: apiInt64(1024 * (i + 1)),
+			Datetime: "2024-04-01 09:30:00",
+		})
+	}
+	resp.Total = m.FilesPerNode
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// fileDownload serves the content of a file listed by filesList: its size
+// in repeated bytes, with Range support from http.ServeContent.
+func (m *mockDirectCloud) fileDownload(w http.ResponseWriter, r *http.Request) {
+	fileSeq := r.URL.Query().Get("file_seq")
+	_, index, ok := strings.Cut(fileSeq, "-f")
+	i, err := strconv.Atoi(index)
+	if !ok || err != nil || i >= m.FilesPerNode {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(AdminAuthTokenErrorResponse{
+			Success: false,
+			All: "file not found",
+		})
+		return
+	}
+	content := bytes.Repeat([]byte{byte('a' + i%26)}, 1024*(i+1))
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, fmt.Sprintf("file-%d.txt", i), time.Time{}, bytes.NewReader(content))
+}
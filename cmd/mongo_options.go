@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var mongoURIPassword = regexp.MustCompile(`://([^:@/]*):[^@/]*@`)
+
+// mongoURI returns MONGO_URI (or MONGO_URI_FILE), falling back to
+// mongodb://MONGO_HOST:MONGO_PORT. Every driver option can be set in the URI:
+// replicaSet, authSource, authMechanism, tls, tlsCAFile,
+// tlsCertificateKeyFile, readPreference, readConcernLevel, w, journal and so on.
+func mongoURI() (string, error) {
+	uri, err := envOrFile("MONGO_URI")
+	if err != nil {
+		return "", err
+	}
+	if uri != "" {
+		return uri, nil
+	}
+	mongoHost := os.Getenv("MONGO_HOST")
+	if mongoHost == "" {
+		return "", fmt.Errorf("Neither MONGO_URI nor MONGO_HOST is set")
+	}
+	mongoPort := os.Getenv("MONGO_PORT")
+	if mongoPort == "" {
+		return "", fmt.Errorf("MONGO_PORT environment variable is not set")
+	}
+	return fmt.Sprintf("mongodb://%s:%s", mongoHost, mongoPort), nil
+}
+
+// redactMongoURI hides the password so the URI can be logged.
+func redactMongoURI(uri string) string {
+	return mongoURIPassword.ReplaceAllString(uri, "://$1:xxxxx@")
+}
+
+// mongoURIDatabase returns the database named in the URI path, if any. It
+// does not use the driver's parser, which resolves mongodb+srv hosts.
+func mongoURIDatabase(uri string) string {
+	_, rest, ok := strings.Cut(uri, "://")
+	if !ok {
+		return ""
+	}
+	_, path, ok := strings.Cut(rest, "/")
+	if !ok {
+		return ""
+	}
+	path, _, _ = strings.Cut(path, "?")
+	db, err := url.PathUnescape(path)
+	if err != nil {
+		return ""
+	}
+	return db
+}
+
+// mongoClientOptions applies the URI and then the settings that are easier to
+// keep out of it: MONGO_USERNAME with MONGO_PASSWORD(_FILE), and TLS files as
+// mounted from Kubernetes secrets.
+func mongoClientOptions(uri string) (*options.ClientOptions, error) {
+	opts := options.Client().ApplyURI(uri)
+	if username := os.Getenv("MONGO_USERNAME"); username != "" {
+		password, err := envOrFile("MONGO_PASSWORD")
+		if err != nil {
+			return nil, err
+		}
+		cred := options.Credential{
+			Username: username,
+			Password: password,
+			PasswordSet: password != "",
+			AuthSource: os.Getenv("MONGO_AUTH_SOURCE"),
+			AuthMechanism: os.Getenv("MONGO_AUTH_MECHANISM"),
+		}
+		opts.SetAuth(cred)
+	}
+	tlsConfig, err := mongoTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		opts.SetTLSConfig(tlsConfig)
+	}
+	return opts, nil
+}
+
+// mongoTLSConfig builds a TLS config from MONGO_TLS_CA_FILE and the client
+// certificate pair MONGO_TLS_CERT_FILE / MONGO_TLS_KEY_FILE. It returns nil
+// when none are set, leaving TLS to the URI.
+func mongoTLSConfig() (*tls.Config, error) {
+	caFile := os.Getenv("MONGO_TLS_CA_FILE")
+	certFile := os.Getenv("MONGO_TLS_CERT_FILE")
+	keyFile := os.Getenv("MONGO_TLS_KEY_FILE")
+	if caFile == "" && certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read MONGO_TLS_CA_FILE: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificates found in MONGO_TLS_CA_FILE %s", caFile)
+		}
+		config.RootCAs = pool
+	}
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return nil, fmt.Errorf("MONGO_TLS_CERT_FILE and MONGO_TLS_KEY_FILE must be set together")
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to load the MongoDB client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
@@ -0,0 +1,207 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+)
+
+// nodeQueue is the crawl frontier shared by the sync workers.
+// Push never blocks, so a worker can enqueue the children of the node it is
+// processing without waiting for another worker to make room.
+type nodeQueue interface {
+	Push(ctx context.Context, node string) error
+	// Pop blocks until a node is available. ok is false once the queue is
+	// empty and every popped node has been marked Done.
+	Pop(ctx context.Context) (node string, ok bool, err error)
+	Done(ctx context.Context, node string) error
+	Len() int
+	Close() error
+}
+
+// nodeSpill stores the part of the frontier that does not fit in memory.
+type nodeSpill interface {
+	Write(ctx context.Context, nodes []string) error
+	Read(ctx context.Context, max int) ([]string, error)
+	Close() error
+}
+
+var errNodeQueueClosed = errors.New("node queue is closed")
+
+// errNodeQueueFull is returned by Push when the queue has no spill and
+// capacity nodes are already waiting.
+var errNodeQueueFull = errors.New("node queue is full and has no spill")
+
+// memoryNodeQueue keeps at most capacity nodes in memory and writes the rest
+// to its spill in batches of spillBatch. Without a spill, Push fails once
+// capacity nodes are waiting.
+type memoryNodeQueue struct {
+	mu         sync.Mutex
+	changed    chan struct{}
+	buf        []string
+	head       int
+	pending    []string
+	capacity   int
+	spillBatch int
+	spill      nodeSpill
+	spilled    int
+	inflight   int
+	closed     bool
+}
+
+func newMemoryNodeQueue(capacity int, spill nodeSpill) *memoryNodeQueue {
+	spillBatch := capacity / 4
+	if spillBatch < 1 {
+		spillBatch = 1
+	}
+	return &memoryNodeQueue{
+		changed:    make(chan struct{}),
+		buf:        make([]string, 0, capacity),
+		capacity:   capacity,
+		spillBatch: spillBatch,
+		spill:      spill,
+	}
+}
+
+func (q *memoryNodeQueue) broadcast() {
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
+
+func (q *memoryNodeQueue) Push(ctx context.Context, node string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return errNodeQueueClosed
+	}
+	if q.spilled == 0 && len(q.pending) == 0 && len(q.buf)-q.head < q.capacity {
+		q.compact()
+		q.buf = append(q.buf, node)
+		q.broadcast()
+		return nil
+	}
+	if q.spill == nil {
+		return errNodeQueueFull
+	}
+	q.pending = append(q.pending, node)
+	if len(q.pending) >= q.spillBatch {
+		if err := q.flushPending(ctx); err != nil {
+			return err
+		}
+	}
+	q.broadcast()
+	return nil
+}
+
+func (q *memoryNodeQueue) flushPending(ctx context.Context) error {
+	if len(q.pending) == 0 {
+		return nil
+	}
+	if err := q.spill.Write(ctx, q.pending); err != nil {
+		return err
+	}
+	q.spilled += len(q.pending)
+	q.pending = q.pending[:0]
+	return nil
+}
+
+// compact drops the consumed prefix of buf so that it does not grow past
+// capacity.
+func (q *memoryNodeQueue) compact() {
+	if q.head == 0 {
+		return
+	}
+	n := copy(q.buf, q.buf[q.head:])
+	clear(q.buf[n:])
+	q.buf = q.buf[:n]
+	q.head = 0
+}
+
+// refill moves spilled nodes back into memory. The caller holds q.mu.
+func (q *memoryNodeQueue) refill(ctx context.Context) error {
+	if q.spilled == 0 {
+		if len(q.pending) == 0 {
+			return nil
+		}
+		q.compact()
+		n := min(len(q.pending), q.capacity)
+		q.buf = append(q.buf, q.pending[:n]...)
+		q.pending = append(q.pending[:0], q.pending[n:]...)
+		return nil
+	}
+	q.compact()
+	nodes, err := q.spill.Read(ctx, min(q.spilled, q.capacity-len(q.buf)))
+	if err != nil {
+		return err
+	}
+	q.spilled -= len(nodes)
+	q.buf = append(q.buf, nodes...)
+	return nil
+}
+
+func (q *memoryNodeQueue) Pop(ctx context.Context) (string, bool, error) {
+	for {
+		q.mu.Lock()
+		if q.head == len(q.buf) && (q.spilled > 0 || len(q.pending) > 0) {
+			if err := q.refill(ctx); err != nil {
+				q.mu.Unlock()
+				return "", false, err
+			}
+		}
+		if q.head < len(q.buf) {
+			node := q.buf[q.head]
+			q.buf[q.head] = ""
+			q.head++
+			q.inflight++
+			q.mu.Unlock()
+			return node, true, nil
+		}
+		if q.closed || q.inflight == 0 {
+			q.closed = true
+			q.broadcast()
+			q.mu.Unlock()
+			return "", false, nil
+		}
+		changed := q.changed
+		q.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return "", false, ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+func (q *memoryNodeQueue) Done(ctx context.Context, node string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.inflight--
+	q.broadcast()
+	return nil
+}
+
+func (q *memoryNodeQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.buf) - q.head + len(q.pending) + q.spilled
+}
+
+func (q *memoryNodeQueue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.closed {
+		q.closed = true
+		q.broadcast()
+	}
+	if q.spill == nil {
+		return nil
+	}
+	if err := q.spill.Close(); err != nil {
+		slog.Warn("Failed to clean up node queue spill",
+			"error", err,
+			)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,271 @@
+package cmd
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// NODE_QUEUE_MIN_VISIBILITY keeps leases long enough to be renewed: they are
+// renewed every third of the visibility timeout, in whole milliseconds.
+const NODE_QUEUE_MIN_VISIBILITY = time.Second
+
+// NODE_QUEUE_SEEDED_TTL is how long the seeded marker outlives the last
+// process of a run, so that a process joining late finds the run finished
+// instead of starting it over.
+const NODE_QUEUE_SEEDED_TTL = 5 * time.Minute
+
+// redisNodeQueue is a nodeQueue shared by several sync processes.
+// Popped nodes are leased to the popping process until it calls Done. A
+// lease that is not renewed within the visibility timeout (for example
+// because the process died) puts the node back into the pending list. Every
+// lease carries a token, so a process whose lease expired cannot renew or
+// release the lease another process has taken since.
+//
+// Keys share a hash tag so that the scripts also work on Redis Cluster:
+//
+//	{sharedbox:sync:<name>}:pending  list of nodes waiting to be fetched
+//	{sharedbox:sync:<name>}:leases   sorted set of leased nodes by deadline
+//	{sharedbox:sync:<name>}:tokens   hash of the token of every lease
+//	{sharedbox:sync:<name>}:seeded   set by the process that pushed the root,
+//	                                 kept alive by every process of the run
+//	                                 and expiring NODE_QUEUE_SEEDED_TTL after
+//	                                 the last one closed the queue
+type redisNodeQueue struct {
+	client       redis.UniversalClient
+	pendingKey   string
+	leasesKey    string
+	tokensKey    string
+	seededKey    string
+	visibility   time.Duration
+	pollInterval time.Duration
+
+	mu     sync.Mutex
+	// leased holds the tokens of the leases on every node, oldest first: a
+	// node whose lease expired can be popped again by another worker of the
+	// same process before the first one is done with it.
+	leased map[string][]string
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// redisNodeQueuePopScript requeues expired leases, then leases the next
+// pending node under the token ARGV[2]. It returns {node} or, when nothing
+// is pending, {false, pendingCount, leaseCount}. Once nothing is pending or
+// leased the run is over; the seeded marker is left to expire.
+var redisNodeQueuePopScript = redis.NewScript(`
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local expired = redis.call('ZRANGEBYSCORE', KEYS[2], '-inf', now)
+for _, node in ipairs(expired) do
+	redis.call('ZREM', KEYS[2], node)
+	redis.call('HDEL', KEYS[3], node)
+	redis.call('RPUSH', KEYS[1], node)
+end
+local node = redis.call('LPOP', KEYS[1])
+if node then
+	redis.call('ZADD', KEYS[2], now + tonumber(ARGV[1]), node)
+	redis.call('HSET', KEYS[3], node, ARGV[2])
+	return {node}
+end
+return {false, redis.call('LLEN', KEYS[1]), redis.call('ZCARD', KEYS[2])}
+`)
+
+// redisNodeQueueSeedScript pushes the root node and marks the run as seeded
+// in one step, so a process that joins concurrently never sees an empty,
+// unseeded queue.
+var redisNodeQueueSeedScript = redis.NewScript(`
+if redis.call('SET', KEYS[2], ARGV[2], 'NX', 'PX', ARGV[3]) then
+	redis.call('RPUSH', KEYS[1], ARGV[1])
+	return 1
+end
+return 0
+`)
+
+// redisNodeQueueRenewScript extends the seeded marker and the leases this
+// process still holds. ARGV holds the marker TTL and the visibility timeout
+// followed by node, token pairs.
+var redisNodeQueueRenewScript = redis.NewScript(`
+redis.call('PEXPIRE', KEYS[3], ARGV[1])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+for i = 3, #ARGV, 2 do
+	if redis.call('HGET', KEYS[2], ARGV[i]) == ARGV[i + 1] then
+		redis.call('ZADD', KEYS[1], 'XX', now + tonumber(ARGV[2]), ARGV[i])
+	end
+end
+return 0
+`)
+
+// redisNodeQueueDoneScript releases the lease on ARGV[1] if it is still
+// held under the token ARGV[2].
+var redisNodeQueueDoneScript = redis.NewScript(`
+if redis.call('HGET', KEYS[2], ARGV[1]) ~= ARGV[2] then
+	return 0
+end
+redis.call('ZREM', KEYS[1], ARGV[1])
+redis.call('HDEL', KEYS[2], ARGV[1])
+return 1
+`)
+
+func newRedisNodeQueue(name string, visibility time.Duration) *redisNodeQueue {
+	prefix := redisKey(fmt.Sprintf("{sharedbox:sync:%s}", name))
+	q := &redisNodeQueue{
+		client: redisClient,
+		pendingKey: prefix + ":pending",
+		leasesKey: prefix + ":leases",
+		tokensKey: prefix + ":tokens",
+		seededKey: prefix + ":seeded",
+		visibility: visibility,
+		pollInterval: 200 * time.Millisecond,
+		leased: map[string][]string{},
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go q.renewLoop()
+	return q
+}
+
+// Seed pushes the root node unless another process already did for the
+// current run. It reports whether this process seeded the queue.
+func (q *redisNodeQueue) Seed(ctx context.Context, rootNode string) (bool, error) {
+	seeded, err := redisNodeQueueSeedScript.Run(
+		ctx,
+		q.client,
+		[]string{q.pendingKey, q.seededKey},
+		rootNode,
+		sessionID,
+		NODE_QUEUE_SEEDED_TTL.Milliseconds(),
+		).Int()
+	if err != nil {
+		return false, fmt.Errorf("Failed to seed distributed queue: %w", err)
+	}
+	return seeded == 1, nil
+}
+
+func (q *redisNodeQueue) Push(ctx context.Context, node string) error {
+	if err := q.client.RPush(ctx, q.pendingKey, node).Err(); err != nil {
+		return fmt.Errorf("Failed to push node to distributed queue: %w", err)
+	}
+	return nil
+}
+
+func (q *redisNodeQueue) Pop(ctx context.Context) (string, bool, error) {
+	for {
+		token := rand.Text()
+		res, err := redisNodeQueuePopScript.Run(
+			ctx,
+			q.client,
+			[]string{q.pendingKey, q.leasesKey, q.tokensKey, q.seededKey},
+			q.visibility.Milliseconds(),
+			token,
+			).Slice()
+		if err != nil {
+			return "", false, fmt.Errorf("Failed to pop node from distributed queue: %w", err)
+		}
+		if node, ok := res[0].(string); ok {
+			q.mu.Lock()
+			q.leased[node] = append(q.leased[node], token)
+			q.mu.Unlock()
+			return node, true, nil
+		}
+		pending, _ := res[1].(int64)
+		leases, _ := res[2].(int64)
+		if pending == 0 && leases == 0 {
+			return "", false, nil
+		}
+		select {
+		case <-ctx.Done():
+			return "", false, ctx.Err()
+		case <-time.After(q.pollInterval):
+		}
+	}
+}
+
+func (q *redisNodeQueue) Done(ctx context.Context, node string) error {
+	q.mu.Lock()
+	var token string
+	if tokens := q.leased[node]; len(tokens) > 0 {
+		token = tokens[0]
+		if len(tokens) == 1 {
+			delete(q.leased, node)
+		} else {
+			q.leased[node] = tokens[1:]
+		}
+	}
+	q.mu.Unlock()
+	released, err := redisNodeQueueDoneScript.Run(
+		ctx,
+		q.client,
+		[]string{q.leasesKey, q.tokensKey},
+		node,
+		token,
+		).Int()
+	if err != nil {
+		return fmt.Errorf("Failed to release node lease: %w", err)
+	}
+	if released == 0 {
+		// The node was requeued after the lease expired and is fetched
+		// again, which only rewrites the same documents.
+		slog.WarnContext(ctx, "Node lease expired before the node was done",
+			"node", node,
+			)
+	}
+	return nil
+}
+
+func (q *redisNodeQueue) Len() int {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	n, err := q.client.LLen(ctx, q.pendingKey).Result()
+	if err != nil {
+		return -1
+	}
+	return int(n)
+}
+
+func (q *redisNodeQueue) Close() error {
+	close(q.stop)
+	<-q.done
+	return nil
+}
+
+func (q *redisNodeQueue) renew() {
+	q.mu.Lock()
+	args := make([]any, 0, 2*len(q.leased)+2)
+	args = append(args, NODE_QUEUE_SEEDED_TTL.Milliseconds(), q.visibility.Milliseconds())
+	for node, tokens := range q.leased {
+		for _, token := range tokens {
+			args = append(args, node, token)
+		}
+	}
+	q.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := redisNodeQueueRenewScript.Run(ctx, q.client, []string{q.leasesKey, q.tokensKey, q.seededKey}, args...).Err()
+	if err != nil {
+		slog.Warn("Failed to renew node leases",
+			"count", (len(args)-2)/2,
+			"error", err,
+			)
+	}
+}
+
+func (q *redisNodeQueue) renewLoop() {
+	defer close(q.done)
+	ticker := time.NewTicker(q.visibility / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-q.stop:
+			return
+		case <-ticker.C:
+		}
+		q.renew()
+	}
+}
@@ -0,0 +1,160 @@
+package cmd
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// testRedis points redisClient at an in-memory Redis for the test. The
+// returned function moves the clock of its TIME command, which the lease
+// scripts use.
+func testRedis(t *testing.T) (*miniredis.Miniredis, func(time.Duration)) {
+	t.Helper()
+	m := miniredis.RunT(t)
+	now := time.Now()
+	m.SetTime(now)
+	client := redis.NewUniversalClient(&redis.UniversalOptions{
+		Addrs: []string{m.Addr()},
+	})
+	previous := redisClient
+	redisClient = client
+	t.Cleanup(func() {
+		redisClient = previous
+		client.Close()
+	})
+	return m, func(d time.Duration) {
+		now = now.Add(d)
+		m.SetTime(now)
+	}
+}
+
+// testRedisNodeQueue returns a queue whose leases are only renewed by
+// calling renew, so that tests control when they expire.
+func testRedisNodeQueue(t *testing.T, name string) *redisNodeQueue {
+	t.Helper()
+	q := newRedisNodeQueue(name, time.Hour)
+	if err := q.Close(); err != nil {
+		t.Fatal(err)
+	}
+	q.visibility = time.Second
+	return q
+}
+
+func TestRedisNodeQueueLeases(t *testing.T) {
+	ctx := context.Background()
+	m, advance := testRedis(t)
+	first := testRedisNodeQueue(t, "test")
+	second := testRedisNodeQueue(t, "test")
+	leased := func() int {
+		n, err := redisClient.ZCard(ctx, first.leasesKey).Result()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return int(n)
+	}
+	pop := func(q *redisNodeQueue, want string) {
+		t.Helper()
+		node, ok, err := q.Pop(ctx)
+		if err != nil || !ok || node != want {
+			t.Fatalf("Pop() = %q, %v, %v, expected %q", node, ok, err, want)
+		}
+	}
+
+	if seeded, err := first.Seed(ctx, "root"); err != nil || !seeded {
+		t.Fatalf("Seed() = %v, %v, expected true", seeded, err)
+	}
+	if seeded, err := second.Seed(ctx, "root"); err != nil || seeded {
+		t.Fatalf("second Seed() = %v, %v, expected false", seeded, err)
+	}
+	pop(first, "root")
+
+	// A renewed lease survives past the visibility timeout.
+	advance(700 * time.Millisecond)
+	first.renew()
+	advance(700 * time.Millisecond)
+	if err := second.Push(ctx, "child"); err != nil {
+		t.Fatal(err)
+	}
+	pop(second, "child")
+	if err := second.Done(ctx, "child"); err != nil {
+		t.Fatal(err)
+	}
+	if got := leased(); got != 1 {
+		t.Fatalf("%d leases after renewing, expected 1", got)
+	}
+
+	// Once expired, the node goes to the next process, and the first
+	// one can no longer release or renew the new lease.
+	advance(2 * time.Second)
+	pop(second, "root")
+	if err := first.Done(ctx, "root"); err != nil {
+		t.Fatal(err)
+	}
+	if got := leased(); got != 1 {
+		t.Fatalf("%d leases after a stale Done, expected 1", got)
+	}
+	token, _ := redisClient.HGet(ctx, first.tokensKey, "root").Result()
+	if token != second.leased["root"][0] {
+		t.Fatalf("lease token %q, expected the one of the second process", token)
+	}
+	if err := second.Done(ctx, "root"); err != nil {
+		t.Fatal(err)
+	}
+	if got := leased(); got != 0 {
+		t.Fatalf("%d leases after Done, expected 0", got)
+	}
+
+	// A drained queue keeps its seeded marker, so a late process does not
+	// start the run over, until the marker expires.
+	if _, ok, err := second.Pop(ctx); err != nil || ok {
+		t.Fatalf("Pop() on a drained queue = %v, %v, expected false", ok, err)
+	}
+	late := testRedisNodeQueue(t, "test")
+	if seeded, err := late.Seed(ctx, "root"); err != nil || seeded {
+		t.Fatalf("late Seed() = %v, %v, expected false", seeded, err)
+	}
+	m.FastForward(NODE_QUEUE_SEEDED_TTL)
+	if seeded, err := late.Seed(ctx, "root"); err != nil || !seeded {
+		t.Fatalf("Seed() after the marker expired = %v, %v, expected true", seeded, err)
+	}
+}
+
+func TestRedisNodeQueueSameNodeTwice(t *testing.T) {
+	ctx := context.Background()
+	_, advance := testRedis(t)
+	q := testRedisNodeQueue(t, "test")
+	if _, err := q.Seed(ctx, "root"); err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		node, ok, err := q.Pop(ctx)
+		if err != nil || !ok || node != "root" {
+			t.Fatalf("Pop() = %q, %v, %v, expected root", node, ok, err)
+		}
+		advance(2 * time.Second)
+	}
+	if got := len(q.leased["root"]); got != 2 {
+		t.Fatalf("%d tokens for root, expected 2", got)
+	}
+	advance(-1500 * time.Millisecond)
+	// The first Done carries the expired token and leaves the live lease.
+	if err := q.Done(ctx, "root"); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := redisClient.ZCard(ctx, q.leasesKey).Result(); n != 1 {
+		t.Fatalf("%d leases after the first Done, expected 1", n)
+	}
+	if err := q.Done(ctx, "root"); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := redisClient.ZCard(ctx, q.leasesKey).Result(); n != 0 {
+		t.Fatalf("%d leases after the second Done, expected 0", n)
+	}
+	if len(q.leased) != 0 {
+		t.Fatalf("tokens left after Done: %v", q.leased)
+	}
+}
@@ -0,0 +1,133 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	NODE_QUEUE_SPILL_NONE  = "none"
+	NODE_QUEUE_SPILL_DISK  = "disk"
+	NODE_QUEUE_SPILL_REDIS = "redis"
+)
+
+func newNodeSpill(kind string, dir string, key string) (nodeSpill, error) {
+	switch kind {
+	case NODE_QUEUE_SPILL_NONE, "":
+		return nil, nil
+	case NODE_QUEUE_SPILL_DISK:
+		return newDiskNodeSpill(dir)
+	case NODE_QUEUE_SPILL_REDIS:
+		if redisClient == nil {
+			return nil, fmt.Errorf("Redis client is not initialized")
+		}
+		return &redisNodeSpill{
+			client: redisClient,
+			key:    redisKey(key),
+		}, nil
+	default:
+		return nil, fmt.Errorf("Unknown spill type %q (expected %s, %s or %s)",
+			kind, NODE_QUEUE_SPILL_NONE, NODE_QUEUE_SPILL_DISK, NODE_QUEUE_SPILL_REDIS)
+	}
+}
+
+// diskNodeSpill is a FIFO backed by a temporary file, one node per line.
+// Nodes are only read after they have been written in full, so the reader
+// never sees a partial line.
+type diskNodeSpill struct {
+	w *os.File
+	r *os.File
+	br *bufio.Reader
+}
+
+func newDiskNodeSpill(dir string) (*diskNodeSpill, error) {
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("Failed to create spill directory: %w", err)
+		}
+	}
+	w, err := os.CreateTemp(dir, "sharedbox-sync-*.spill")
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create spill file: %w", err)
+	}
+	r, err := os.Open(w.Name())
+	if err != nil {
+		w.Close()
+		os.Remove(w.Name())
+		return nil, fmt.Errorf("Failed to open spill file: %w", err)
+	}
+	return &diskNodeSpill{
+		w: w,
+		r: r,
+		br: bufio.NewReader(r),
+	}, nil
+}
+
+func (s *diskNodeSpill) Write(ctx context.Context, nodes []string) error {
+	var b strings.Builder
+	for _, node := range nodes {
+		b.WriteString(node)
+		b.WriteByte('\n')
+	}
+	if _, err := s.w.WriteString(b.String()); err != nil {
+		return fmt.Errorf("Failed to write spill file: %w", err)
+	}
+	return nil
+}
+
+func (s *diskNodeSpill) Read(ctx context.Context, max int) ([]string, error) {
+	nodes := make([]string, 0, max)
+	for len(nodes) < max {
+		line, err := s.br.ReadString('\n')
+		if err != nil {
+			return nodes, fmt.Errorf("Failed to read spill file: %w", err)
+		}
+		nodes = append(nodes, strings.TrimSuffix(line, "\n"))
+	}
+	return nodes, nil
+}
+
+func (s *diskNodeSpill) Close() error {
+	return errors.Join(
+		s.r.Close(),
+		s.w.Close(),
+		os.Remove(s.w.Name()),
+		)
+}
+
+// redisNodeSpill is a FIFO backed by a Redis list.
+type redisNodeSpill struct {
+	client redis.UniversalClient
+	key    string
+}
+
+func (s *redisNodeSpill) Write(ctx context.Context, nodes []string) error {
+	pipe := s.client.TxPipeline()
+	pipe.RPush(ctx, s.key, nodes)
+	pipe.Expire(ctx, s.key, 24*time.Hour)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("Failed to push spilled nodes to Redis: %w", err)
+	}
+	return nil
+}
+
+func (s *redisNodeSpill) Read(ctx context.Context, max int) ([]string, error) {
+	nodes, err := s.client.LPopCount(ctx, s.key, max).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, fmt.Errorf("Failed to pop spilled nodes from Redis: %w", err)
+	}
+	return nodes, nil
+}
+
+func (s *redisNodeSpill) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	return s.client.Del(ctx, s.key).Err()
+}
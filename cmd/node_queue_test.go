@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func TestMemoryNodeQueue(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		capacity int
+		spill string
+		nodes int
+		wantErr error
+	}{
+		{name: "fits in memory", capacity: 8, nodes: 8},
+		{name: "full without spill", capacity: 8, nodes: 9, wantErr: errNodeQueueFull},
+		{name: "disk spill", capacity: 4, spill: NODE_QUEUE_SPILL_DISK, nodes: 50},
+		{name: "redis spill", capacity: 4, spill: NODE_QUEUE_SPILL_REDIS, nodes: 50},
+		{name: "capacity of one", capacity: 1, spill: NODE_QUEUE_SPILL_DISK, nodes: 10},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tc.spill == NODE_QUEUE_SPILL_REDIS {
+				testRedis(t)
+			}
+			spill, err := newNodeSpill(tc.spill, t.TempDir(), "test:spill")
+			if err != nil {
+				t.Fatal(err)
+			}
+			q := newMemoryNodeQueue(tc.capacity, spill)
+			defer q.Close()
+			var want []string
+			for i := range tc.nodes {
+				node := fmt.Sprintf("n%d", i)
+				if err := q.Push(ctx, node); err != nil {
+					if !errors.Is(err, tc.wantErr) {
+						t.Fatalf("Push(%s) = %v, expected %v", node, err, tc.wantErr)
+					}
+					break
+				}
+				want = append(want, node)
+			}
+			if got := q.Len(); got != len(want) {
+				t.Fatalf("Len() = %d, expected %d", got, len(want))
+			}
+			// Pushing while popping keeps the order across memory and spill.
+			extra := fmt.Sprintf("n%d", tc.nodes)
+			var got []string
+			for {
+				node, ok, err := q.Pop(ctx)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !ok {
+					break
+				}
+				got = append(got, node)
+				if len(got) == 1 && tc.wantErr == nil {
+					if err := q.Push(ctx, extra); err != nil {
+						t.Fatal(err)
+					}
+					want = append(want, extra)
+				}
+				if err := q.Done(ctx, node); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if !slices.Equal(got, want) {
+				t.Errorf("popped %v, expected %v", got, want)
+			}
+			if err := q.Push(ctx, "late"); !errors.Is(err, errNodeQueueClosed) {
+				t.Errorf("Push after draining = %v, expected errNodeQueueClosed", err)
+			}
+		})
+	}
+}
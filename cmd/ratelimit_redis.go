@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// rateWaiter is satisfied by *rate.Limiter and by redisRateLimiter.
+type rateWaiter interface {
+	Wait(ctx context.Context) error
+}
+
+// redisRateLimiter enforces one request rate across every process that uses
+// the same key. It implements GCRA with the Redis server clock, so the hosts
+// do not need synchronised clocks.
+type redisRateLimiter struct {
+	client   redis.UniversalClient
+	key      string
+	interval time.Duration
+}
+
+// redisRateLimiterScript returns 0 when a request may proceed, otherwise the
+// number of microseconds to wait before trying again.
+var redisRateLimiterScript = redis.NewScript(`
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+local interval = tonumber(ARGV[1])
+local tat = tonumber(redis.call('GET', KEYS[1]) or now)
+if tat < now then
+	tat = now
+end
+if tat > now then
+	return tat - now
+end
+redis.call('SET', KEYS[1], tat + interval, 'PX', math.ceil(interval / 1000) + 1000)
+return 0
+`)
+
+func newRedisRateLimiter(name string, reqPerSec float64) (*redisRateLimiter, error) {
+	if reqPerSec <= 0 {
+		return nil, fmt.Errorf("Global rate must be positive")
+	}
+	return &redisRateLimiter{
+		client: redisClient,
+		key: redisKey(fmt.Sprintf("ratelimit:%s", name)),
+		interval: time.Duration(float64(time.Second) / reqPerSec),
+	}, nil
+}
+
+func (l *redisRateLimiter) Wait(ctx context.Context) error {
+	for {
+		wait, err := redisRateLimiterScript.Run(
+			ctx,
+			l.client,
+			[]string{l.key},
+			l.interval.Microseconds(),
+			).Int64()
+		if err != nil {
+			return fmt.Errorf("Failed to acquire global rate limit: %w", err)
+		}
+		if wait == 0 {
+			return nil
+		}
+		d := time.Duration(wait) * time.Microsecond
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < d {
+			// Wrapped so that withAPIRetry retries it like a request timeout.
+			return fmt.Errorf("Global rate limit wait of %s exceeds context deadline: %w", d, context.DeadlineExceeded)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(d):
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package cmd
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/redis/go-redis/v9/maintnotifications"
+)
+
+const (
+	REDIS_MODE_STANDALONE = "standalone"
+	REDIS_MODE_SENTINEL = "sentinel"
+	REDIS_MODE_CLUSTER = "cluster"
+)
+
+// redisKeyPrefix is prepended to every key so several environments can share
+// one Redis.
+var redisKeyPrefix string
+
+func redisKey(key string) string {
+	return redisKeyPrefix + key
+}
+
+// redisConfigured reports whether any Redis address is set. Syncs use Redis
+// when it is configured even if they could do without it, so that their lock
+// is shared between hosts.
+func redisConfigured() bool {
+	for _, env := range []string{"REDIS_URL", "REDIS_URL_FILE", "REDIS_ADDRS", "REDIS_HOST"} {
+		if os.Getenv(env) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// redisUniversalOptions reads the Redis settings. The address comes from
+// REDIS_URL (redis://, rediss:// for TLS), REDIS_ADDRS (comma separated
+// sentinel or cluster nodes) or REDIS_HOST and REDIS_PORT. The separate
+// variables override what the URL sets.
+func redisUniversalOptions() (*redis.UniversalOptions, string, error) {
+	opts := &redis.UniversalOptions{
+		MaintNotificationsConfig: &maintnotifications.Config{
+			Mode: maintnotifications.ModeDisabled,
+		},
+	}
+	redisURL, err := envOrFile("REDIS_URL")
+	if err != nil {
+		return nil, "", err
+	}
+	if redisURL != "" {
+		parsed, err := redis.ParseURL(redisURL)
+		if err != nil {
+			return nil, "", fmt.Errorf("Invalid REDIS_URL: %w", err)
+		}
+		opts.Addrs = []string{parsed.Addr}
+		opts.Username = parsed.Username
+		opts.Password = parsed.Password
+		opts.DB = parsed.DB
+		opts.TLSConfig = parsed.TLSConfig
+	}
+	if addrs := os.Getenv("REDIS_ADDRS"); addrs != "" {
+		opts.Addrs = strings.Split(addrs, ",")
+	}
+	if len(opts.Addrs) == 0 {
+		redisHost := os.Getenv("REDIS_HOST")
+		if redisHost == "" {
+			return nil, "", fmt.Errorf("None of REDIS_URL, REDIS_ADDRS or REDIS_HOST is set")
+		}
+		redisPort := os.Getenv("REDIS_PORT")
+		if redisPort == "" {
+			return nil, "", fmt.Errorf("REDIS_PORT environment variable is not set")
+		}
+		opts.Addrs = []string{fmt.Sprintf("%s:%s", redisHost, redisPort)}
+	}
+	if v := os.Getenv("REDIS_USERNAME"); v != "" {
+		opts.Username = v
+	}
+	password, err := envOrFile("REDIS_PASSWORD")
+	if err != nil {
+		return nil, "", err
+	}
+	if password != "" {
+		opts.Password = password
+	}
+	if v := os.Getenv("REDIS_DB"); v != "" {
+		db, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, "", fmt.Errorf("REDIS_DB must be an integer: %v", err)
+		}
+		opts.DB = db
+	}
+	tlsConfig, err := redisTLSConfig(opts.TLSConfig)
+	if err != nil {
+		return nil, "", err
+	}
+	opts.TLSConfig = tlsConfig
+
+	mode := os.Getenv("REDIS_MODE")
+	switch mode {
+	case REDIS_MODE_STANDALONE, "":
+		mode = REDIS_MODE_STANDALONE
+		if len(opts.Addrs) > 1 {
+			return nil, "", fmt.Errorf("REDIS_ADDRS lists several nodes, set REDIS_MODE to %s or %s", REDIS_MODE_SENTINEL, REDIS_MODE_CLUSTER)
+		}
+	case REDIS_MODE_SENTINEL:
+		opts.MasterName = os.Getenv("REDIS_SENTINEL_MASTER")
+		if opts.MasterName == "" {
+			return nil, "", fmt.Errorf("REDIS_MODE=%s needs REDIS_SENTINEL_MASTER", REDIS_MODE_SENTINEL)
+		}
+		opts.SentinelUsername = os.Getenv("REDIS_SENTINEL_USERNAME")
+		sentinelPassword, err := envOrFile("REDIS_SENTINEL_PASSWORD")
+		if err != nil {
+			return nil, "", err
+		}
+		opts.SentinelPassword = sentinelPassword
+	case REDIS_MODE_CLUSTER:
+		if opts.DB != 0 {
+			return nil, "", fmt.Errorf("Redis cluster only supports DB 0")
+		}
+		opts.IsClusterMode = true
+	default:
+		return nil, "", fmt.Errorf("Unknown REDIS_MODE %q (expected %s, %s or %s)",
+			mode, REDIS_MODE_STANDALONE, REDIS_MODE_SENTINEL, REDIS_MODE_CLUSTER)
+	}
+	return opts, mode, nil
+}
+
+// redisTLSConfig enables TLS when REDIS_TLS is true or a CA or client
+// certificate is given, extending the config from a rediss:// URL if any.
+func redisTLSConfig(config *tls.Config) (*tls.Config, error) {
+	enabled, _ := strconv.ParseBool(os.Getenv("REDIS_TLS"))
+	caFile := os.Getenv("REDIS_TLS_CA_FILE")
+	certFile := os.Getenv("REDIS_TLS_CERT_FILE")
+	keyFile := os.Getenv("REDIS_TLS_KEY_FILE")
+	if !enabled && caFile == "" && certFile == "" && keyFile == "" {
+		return config, nil
+	}
+	if config == nil {
+		config = &tls.Config{
+			MinVersion: tls.VersionTLS12,
+		}
+	}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read REDIS_TLS_CA_FILE: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificates found in REDIS_TLS_CA_FILE %s", caFile)
+		}
+		config.RootCAs = pool
+	}
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return nil, fmt.Errorf("REDIS_TLS_CERT_FILE and REDIS_TLS_KEY_FILE must be set together")
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to load the Redis client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
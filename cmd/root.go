@@ -6,25 +6,22 @@ import (
 	"fmt"
 	"log"
 	"log/slog"
+	"io"
 	"os"
-	"path/filepath"
+	"strconv"
 	"time"
 
 	"github.com/google/uuid"
-	"github.com/joho/godotenv"
 	"github.com/redis/go-redis/v9"
-	"github.com/redis/go-redis/v9/maintnotifications"
 	"github.com/spf13/cobra"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 var (
 	sessionID string
-	logFile   *os.File
+	logFile   *rotatingWriter
 	mongoClient *mongo.Client
-	redisClient *redis.Client
+	redisClient redis.UniversalClient
 	adminApiClient *AdminApiClient
 	mongoDatabase string
 )
@@ -32,6 +29,7 @@ const (
 	MONGO_DEFAULT_DATABASE = "amazing_brain_dead_storage_accessor"
 	MONGO_COLLECTION_SHAREDBOXES = "sharedboxes"
 	MONGO_COLLECTION_USERS = "users"
+	MONGO_COLLECTION_FILES = "files"
 	DIRECTORY_DEFAULT_LOGS = "logs"
 	DIRECTORY_DEFAULT_EXPORT = "exports"
 )
@@ -39,31 +37,48 @@ const (
 var rootCmd = &cobra.Command{
 	Use:   "amazing-brain-dead-storage-accessor",
 	PersistentPreRunE: func (cmd *cobra.Command, args []string) error {
-		_ = godotenv.Load()
+		if err := initSettings(cmd); err != nil {
+			return err
+		}
 		initSessionID()
 		if err := initLogger(cmd); err != nil {
 			return err
 		}
-		if err := initMongoClient(cmd); err != nil {
+		if err := initTenant(cmd); err != nil {
 			return err
 		}
-		if err := initRedisClient(cmd); err != nil {
+		if err := initTracing(cmd); err != nil {
 			return err
 		}
-		if err := initMongoIndexes(cmd); err != nil {
+		if err := initBackends(cmd); err != nil {
 			return err
 		}
+		if metricsAddr, _ := cmd.Flags().GetString("metrics-addr"); metricsAddr != "" {
+			initMetricsServer(cmd.Context(), metricsAddr)
+		}
 		return nil
 	},
 	PersistentPostRunE: func (cmd *cobra.Command, args []string) error {
 		var combinedErr error
 		cmdCtx := cmd.Context()
+		if store != nil {
+			if err := store.Close(cmdCtx); err != nil {
+				log.Printf("Error closing storage: %v", err)
+				combinedErr = errors.Join(combinedErr, err)
+			}
+		}
 		if mongoClient != nil {
 			if err := closeMongoClient(cmdCtx); err != nil {
 				log.Printf("Error closing MongoDB client: %v", err)
 				combinedErr = errors.Join(combinedErr, err)
 			}
 		}
+		if metricsServer != nil {
+			if err := closeMetricsServer(cmdCtx); err != nil {
+				log.Printf("Error closing metrics server: %v", err)
+				combinedErr = errors.Join(combinedErr, err)
+			}
+		}
 		if redisClient != nil {
 			if err := redisClient.Close(); err != nil {
 				log.Printf("Error closing Redis client: %v", err)
@@ -82,15 +97,37 @@ var rootCmd = &cobra.Command{
 
 func init() {
 	rootCmd.PersistentFlags().Bool("verbose", false, "Enable verbose output")
+	rootCmd.PersistentFlags().String("config", "", "Config file (env ABDSA_CONFIG, defaults to ./config.yaml or the user config directory)")
+	rootCmd.PersistentFlags().String("profile", "", "Config profile to use (env ABDSA_PROFILE, defaults to default_profile from the config file)")
+	rootCmd.PersistentFlags().String("tenant", "", "DirectCloud company code to work on (env DIRECTCLOUD_CODE)")
+	rootCmd.PersistentFlags().String("log-format", "text", "Log format: text or json (env LOG_FORMAT)")
+	rootCmd.PersistentFlags().String("log-dir", DIRECTORY_DEFAULT_LOGS, "Directory for the daily debug-<date>.log files (env LOG_DIR)")
+	rootCmd.PersistentFlags().String("log-file", "", "Log to this file instead of daily files in --log-dir, or \"none\" to disable file logging (env LOG_FILE)")
+	rootCmd.PersistentFlags().String("log-stderr", "off", "Also log to stderr at this level: off, debug, info, warn or error (env LOG_STDERR)")
+	rootCmd.PersistentFlags().Int("log-max-size", 0, "Rotate the log file once it exceeds this many MB (0 for no limit) (env LOG_MAX_SIZE)")
+	rootCmd.PersistentFlags().Duration("log-max-age", 0, "Rotate --log-file after it has been written to for this long (0 to disable) (env LOG_MAX_AGE)")
+	rootCmd.PersistentFlags().Duration("log-retention", 0, "Delete rotated log files older than this (0 to keep them) (env LOG_RETENTION)")
+	rootCmd.PersistentFlags().Int("log-max-files", 0, "Keep at most this many log files including the current one (0 for no limit) (env LOG_MAX_FILES)")
+	rootCmd.PersistentFlags().String("trace-exporter", "", "Export OpenTelemetry traces: none, otlp or file (env TRACE_EXPORTER)")
+	rootCmd.PersistentFlags().String("trace-file", "", "File for the file trace exporter (defaults to logs/trace-<date>.jsonl)")
+	rootCmd.PersistentFlags().String("metrics-addr", "", "Serve Prometheus metrics on this address while the command runs (e.g. :9090)")
 	rootCmd.AddCommand(
 		authCmd,
 		userCmd,
 		sharedboxCmd,
+		daemonCmd,
+		serveCmd,
+		configCmd,
+		dbCmd,
 		)
 }
 
 func Execute() {
-	if err := rootCmd.Execute(); err != nil {
+	err := rootCmd.Execute()
+	if traceErr := closeTracing(err); traceErr != nil {
+		slog.Error("Failed to flush traces", "error", traceErr)
+	}
+	if err != nil {
 		slog.Error("Command execution failed", "error", err)
 		os.Exit(1)
 	}
@@ -100,6 +137,29 @@ func initSessionID() {
 	sessionID = uuid.New().String()
 }
 
+// flagOrEnv returns the value of flag name, or of env when the flag was not
+// given. Non-string flags are returned in their string form.
+func flagOrEnv(cmd *cobra.Command, name string, env string) string {
+	value := ""
+	if flag := cmd.Flags().Lookup(name); flag != nil {
+		value = flag.Value.String()
+	}
+	if !cmd.Flags().Changed(name) {
+		if v := os.Getenv(env); v != "" {
+			return v
+		}
+	}
+	return value
+}
+
+func parseLogLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return level, fmt.Errorf("Invalid log level %q", s)
+	}
+	return level, nil
+}
+
 func initLogger(cmd *cobra.Command) error {
 	cmdCtx := cmd.Context()
 	logLevel := slog.LevelInfo
@@ -107,31 +167,82 @@ func initLogger(cmd *cobra.Command) error {
 	if verbose {
 		logLevel = slog.LevelDebug
 	}
-	logDir := "logs"
-	if err := os.Mkdir(logDir, 0755); err != nil {
-		if !os.IsExist(err) {
-			slog.ErrorContext(cmdCtx, "Failed to create log directory",
+	logFormat := flagOrEnv(cmd, "log-format", "LOG_FORMAT")
+	logDir := flagOrEnv(cmd, "log-dir", "LOG_DIR")
+	logPath := flagOrEnv(cmd, "log-file", "LOG_FILE")
+	stderrLevel := flagOrEnv(cmd, "log-stderr", "LOG_STDERR")
+	maxSizeMB, err := strconv.Atoi(flagOrEnv(cmd, "log-max-size", "LOG_MAX_SIZE"))
+	if err != nil {
+		return fmt.Errorf("Invalid log max size: %v", err)
+	}
+	maxAge, err := time.ParseDuration(flagOrEnv(cmd, "log-max-age", "LOG_MAX_AGE"))
+	if err != nil {
+		return fmt.Errorf("Invalid log max age: %v", err)
+	}
+	retention, err := time.ParseDuration(flagOrEnv(cmd, "log-retention", "LOG_RETENTION"))
+	if err != nil {
+		return fmt.Errorf("Invalid log retention: %v", err)
+	}
+	maxFiles, err := strconv.Atoi(flagOrEnv(cmd, "log-max-files", "LOG_MAX_FILES"))
+	if err != nil {
+		return fmt.Errorf("Invalid log max files: %v", err)
+	}
+
+	newHandler := func(w io.Writer, level slog.Level) (slog.Handler, error) {
+		opts := &slog.HandlerOptions{
+			Level: level,
+			AddSource: false,
+		}
+		switch logFormat {
+		case "text", "":
+			return slog.NewTextHandler(w, opts), nil
+		case "json":
+			return slog.NewJSONHandler(w, opts), nil
+		default:
+			return nil, fmt.Errorf("Unknown log format %q (expected text or json)", logFormat)
+		}
+	}
+
+	var handlers []slog.Handler
+	if logPath != "none" {
+		w, err := newRotatingWriter(
+			logDir,
+			logPath,
+			int64(maxSizeMB)*1024*1024,
+			maxAge,
+			retention,
+			maxFiles,
+			)
+		if err != nil {
+			slog.ErrorContext(cmdCtx, "Failed to open log file",
 				"error", err,
 				)
 			return err
 		}
+		logFile = w
+		handler, err := newHandler(w, logLevel)
+		if err != nil {
+			return err
+		}
+		handlers = append(handlers, handler)
 	}
-	now := time.Now().In(time.Local).Format(time.DateOnly)
-	logFile, err := os.OpenFile(
-		filepath.Join(logDir, fmt.Sprintf("debug-%s.log", now)),
-		os.O_CREATE|os.O_WRONLY|os.O_APPEND,
-		0644,
-		)
-	if err != nil {
-		slog.ErrorContext(cmdCtx, "Failed to open log file",
-			"error", err,
-			)
-		return err
+	if stderrLevel != "off" && stderrLevel != "" {
+		level, err := parseLogLevel(stderrLevel)
+		if err != nil {
+			return err
+		}
+		if verbose && level > slog.LevelDebug {
+			level = slog.LevelDebug
+		}
+		handler, err := newHandler(os.Stderr, level)
+		if err != nil {
+			return err
+		}
+		handlers = append(handlers, handler)
 	}
-	logger := slog.New(slog.NewTextHandler(logFile, &slog.HandlerOptions{
-		Level: logLevel,
-		AddSource: false,
-	})).With(
+	logger := slog.New(&fanoutHandler{
+		handlers: handlers,
+	}).With(
 		slog.String("sessionID", sessionID),
 		)
 	slog.SetDefault(logger)
@@ -142,89 +253,42 @@ func initLogger(cmd *cobra.Command) error {
 
 func initMongoClient(cmd *cobra.Command) error {
 	cmdCtx := cmd.Context()
-	mongoHost := os.Getenv("MONGO_HOST")
-	if mongoHost == "" {
-		return fmt.Errorf("MONGO_HOST environment variable is not set")
+	if mongoClient != nil {
+		return nil
 	}
-	mongoPort := os.Getenv("MONGO_PORT")
-	if mongoPort == "" {
-		return fmt.Errorf("MONGO_PORT environment variable is not set")
+	uri, err := mongoURI()
+	if err != nil {
+		return err
+	}
+	opts, err := mongoClientOptions(uri)
+	if err != nil {
+		return err
 	}
-	uri := fmt.Sprintf("mongodb://%s:%s", mongoHost, mongoPort)
 	connectCtx, connectCancel := context.WithTimeout(cmdCtx, 10*time.Second)
 	defer connectCancel()
-	client, err := mongo.Connect(connectCtx, options.Client().ApplyURI(uri))
+	client, err := mongo.Connect(connectCtx, opts)
 	if err != nil {
-		return fmt.Errorf("Failed to connect to MongoDB: %v", err)
+		return fmt.Errorf("Failed to connect to MongoDB at %s: %v", redactMongoURI(uri), err)
 	}
 	pingCtx, pingCancel := context.WithTimeout(cmdCtx, 5*time.Second)
 	defer pingCancel()
 	if err = client.Ping(pingCtx, nil); err != nil {
-		return fmt.Errorf("Failed to ping MongoDB: %w", err)
+		return fmt.Errorf("Failed to ping MongoDB at %s: %w", redactMongoURI(uri), err)
 	}
 	mongoClient = client
 	mongoDatabase = os.Getenv("MONGO_DATABASE")
+	if mongoDatabase == "" {
+		mongoDatabase = mongoURIDatabase(uri)
+	}
 	if mongoDatabase == "" {
 		mongoDatabase = MONGO_DEFAULT_DATABASE
 	}
 	slog.DebugContext(cmdCtx, "MongoDB connection established",
-		"host", mongoHost,
-		"port", mongoPort,
+		"uri", redactMongoURI(uri),
 		"database", mongoDatabase,
 		)
 	return nil
 }
-func initMongoIndexes(cmd *cobra.Command) error {
-	cmdCtx := cmd.Context()
-	slog.DebugContext(cmdCtx, "Using MongoDB database",
-		"database", mongoDatabase,
-		)
-	db := mongoClient.Database(mongoDatabase)
-	var indexErr error
-	func() {
-		collection := db.Collection(MONGO_COLLECTION_SHAREDBOXES)
-		ctx, cancel := context.WithTimeout(cmdCtx, 30*time.Second)
-		defer cancel()
-		_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
-			Keys: bson.D{
-				{Key: "item.node", Value: 1},
-			},
-			Options: options.Index().
-				SetUnique(true).
-				SetName("idx_node_unique"),
-		})
-		if err != nil {
-			slog.ErrorContext(cmdCtx, "Failed to create index on sharedboxes collection",
-				"error", err,
-				)
-			indexErr = errors.Join(indexErr, err)
-			return
-		}
-		slog.DebugContext(cmdCtx, "MongoDB index ensured on sharedboxes collection",)
-	}()
-	func() {
-		collection := db.Collection(MONGO_COLLECTION_USERS)
-		ctx, cancel := context.WithTimeout(cmdCtx, 30*time.Second)
-		defer cancel()
-		_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
-			Keys: bson.D{
-				{Key: "user_seq", Value: 1},
-			},
-			Options: options.Index().
-				SetUnique(true).
-				SetName("idx_user_seq_unique"),
-		})
-		if err != nil {
-			slog.ErrorContext(cmdCtx, "Failed to create index on sharedboxes collection",
-				"error", err,
-				)
-			indexErr = errors.Join(indexErr, err)
-			return
-		}
-		slog.DebugContext(cmdCtx, "MongoDB index ensured on users collection",)
-	}()
-	return indexErr
-}
 func closeMongoClient(cmdCtx context.Context) error {
 	disconnectCtx, disconnectCancel := context.WithTimeout(cmdCtx, 10*time.Second)
 	defer disconnectCancel()
@@ -234,32 +298,31 @@ func closeMongoClient(cmdCtx context.Context) error {
 	return nil
 }
 func initRedisClient(cmd *cobra.Command) error {
-	cmdCtx := cmd.Context()
-	redisHost := os.Getenv("REDIS_HOST")
-	if redisHost == "" {
-		return fmt.Errorf("REDIS_HOST environment variable is not set")
+	return connectRedis(cmd.Context())
+}
+func connectRedis(cmdCtx context.Context) error {
+	if redisClient != nil {
+		return nil
 	}
-	redisPort := os.Getenv("REDIS_PORT")
-	if redisPort == "" {
-		return fmt.Errorf("REDIS_PORT environment variable is not set")
+	opts, mode, err := redisUniversalOptions()
+	if err != nil {
+		return err
 	}
-	addr := fmt.Sprintf("%s:%s", redisHost, redisPort)
-	rdb := redis.NewClient(&redis.Options{
-		Addr: addr,
-		MaintNotificationsConfig: &maintnotifications.Config{
-			Mode: maintnotifications.ModeDisabled,
-		},
-	})
+	rdb := redis.NewUniversalClient(opts)
 	pingCtx, pingCancel := context.WithTimeout(cmdCtx, 5*time.Second)
 	defer pingCancel()
-	_, err := rdb.Ping(pingCtx).Result()
-	if err != nil {
+	if err := rdb.Ping(pingCtx).Err(); err != nil {
+		rdb.Close()
 		return fmt.Errorf("Failed to ping Redis: %w", err)
 	}
 	redisClient = rdb
+	redisKeyPrefix = os.Getenv("REDIS_KEY_PREFIX")
 	slog.DebugContext(cmdCtx, "Redis connection established",
-		"host", redisHost,
-		"port", redisPort,
+		"mode", mode,
+		"addrs", opts.Addrs,
+		"db", opts.DB,
+		"tls", opts.TLSConfig != nil,
+		"keyPrefix", redisKeyPrefix,
 		)
 	return nil
 }
@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"slices"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// normalizeSearchText folds the variants a Japanese folder name is typed in:
+// NFKC turns full-width ASCII into ASCII and half-width katakana (including
+// separate dakuten) into full-width katakana, katakana is then folded to
+// hiragana and Latin letters to lower case.
+func normalizeSearchText(s string) string {
+	s = norm.NFKC.String(s)
+	var b strings.Builder
+	b.Grow(len(s))
+	for _, r := range s {
+		switch {
+		case r >= 'ァ' && r <= 'ヶ':
+			r -= 'ァ' - 'ぁ'
+		case r == 'ヽ' || r == 'ヾ':
+			r -= 'ヽ' - 'ゝ'
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+// searchTokens splits normalized text on spaces and path separators.
+func searchTokens(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsPunct(r) && r != 'ー'
+	})
+}
+
+// searchNgrams returns the distinct bigrams of the tokens of s. Japanese has
+// no word boundaries, so bigrams are what lets a query match inside a name.
+// Single character tokens are kept as they are.
+func searchNgrams(s string) []string {
+	seen := map[string]struct{}{}
+	var grams []string
+	add := func(g string) {
+		if _, ok := seen[g]; !ok {
+			seen[g] = struct{}{}
+			grams = append(grams, g)
+		}
+	}
+	for _, token := range searchTokens(normalizeSearchText(s)) {
+		runes := []rune(token)
+		if len(runes) == 1 {
+			add(token)
+			continue
+		}
+		for i := 0; i+1 < len(runes); i++ {
+			add(string(runes[i : i+2]))
+		}
+	}
+	return grams
+}
+
+// sharedboxSearchNgrams indexes the name and the drive path of item.
+func sharedboxSearchNgrams(item SharedBoxListItemWithParent) []string {
+	grams := searchNgrams(item.Item.Name)
+	for _, g := range searchNgrams(item.Item.DrivePath) {
+		if !slices.Contains(grams, g) {
+			grams = append(grams, g)
+		}
+	}
+	return grams
+}
+
+// ngramOverlap is the share of query grams found in grams.
+func ngramOverlap(query []string, grams []string) float64 {
+	if len(query) == 0 {
+		return 0
+	}
+	set := make(map[string]struct{}, len(grams))
+	for _, g := range grams {
+		set[g] = struct{}{}
+	}
+	hits := 0
+	for _, g := range query {
+		if _, ok := set[g]; ok {
+			hits++
+		}
+	}
+	return float64(hits) / float64(len(query))
+}
+
+// scoreSharedbox ranks item against a normalized query. Matches in the name
+// weigh more than matches elsewhere in the path, whole substring matches
+// more than scattered bigrams, and shorter names win ties so the folder
+// itself ranks above its children.
+func scoreSharedbox(query string, queryGrams []string, item SharedBoxListItemWithParent) float64 {
+	name := normalizeSearchText(item.Item.Name)
+	path := normalizeSearchText(item.Item.DrivePath)
+	nameGrams := searchNgrams(item.Item.Name)
+	score := 2*ngramOverlap(queryGrams, nameGrams) + ngramOverlap(queryGrams, searchNgrams(item.Item.DrivePath))
+	switch {
+	case name == query:
+		score += 3
+	case strings.HasPrefix(name, query):
+		score += 2
+	case strings.Contains(name, query):
+		score += 1.5
+	case strings.Contains(path, query):
+		score += 0.5
+	}
+	if n := len(nameGrams); n > 0 {
+		score += 0.1 * float64(len(queryGrams)) / float64(max(n, len(queryGrams)))
+	}
+	return score
+}
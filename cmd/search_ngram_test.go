@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestNormalizeSearchText(t *testing.T) {
+	for _, tc := range []struct {
+		in string
+		want string
+	}{
+		{in: "エイギョウ", want: "えいぎょう"},
+		{in: "ｴｲｷﾞｮｳ", want: "えいぎょう"},
+		{in: "ＡＢＣ２０２４", want: "abc2024"},
+		{in: "Sales 営業部", want: "sales 営業部"},
+		{in: "ヴ", want: "ゔ"},
+		{in: "ーヽヾ", want: "ーゝゞ"},
+	} {
+		if got := normalizeSearchText(tc.in); got != tc.want {
+			t.Errorf("normalizeSearchText(%q) = %q, expected %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestSearchNgrams(t *testing.T) {
+	for _, tc := range []struct {
+		in string
+		want []string
+	}{
+		{in: "営業部", want: []string{"営業", "業部"}},
+		{in: "/Shared/営業", want: []string{"sh", "ha", "ar", "re", "ed", "営業"}},
+		{in: "部 部", want: []string{"部"}},
+		{in: "ａａａ", want: []string{"aa"}},
+		{in: "データー", want: []string{"でー", "ーた", "たー"}},
+		{in: "", want: nil},
+	} {
+		if got := searchNgrams(tc.in); !slices.Equal(got, tc.want) {
+			t.Errorf("searchNgrams(%q) = %q, expected %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestScoreSharedbox(t *testing.T) {
+	item := func(name, drivePath string) SharedBoxListItemWithParent {
+		return SharedBoxListItemWithParent{
+			Item: SharedBoxListItem{Name: name, DrivePath: drivePath},
+		}
+	}
+	query := normalizeSearchText("営業")
+	grams := searchNgrams(query)
+	exact := scoreSharedbox(query, grams, item("営業", "/営業"))
+	prefix := scoreSharedbox(query, grams, item("営業部", "/営業部"))
+	child := scoreSharedbox(query, grams, item("資料", "/営業/資料"))
+	if !(exact > prefix && prefix > child) {
+		t.Errorf("scores exact %.2f, prefix %.2f, child %.2f, expected them in decreasing order", exact, prefix, child)
+	}
+}
@@ -0,0 +1,265 @@
+package cmd
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	API_DEFAULT_PAGE_LIMIT = 100
+	API_MAX_PAGE_LIMIT = 1000
+	API_MAX_ANCESTOR_DEPTH = 64
+)
+
+var serveApiCmd = &cobra.Command{
+	Use: "api",
+	Annotations: withBackends(BACKEND_STORAGE),
+	RunE: runServeApiCmd,
+}
+
+func init() {
+	serveApiCmd.Flags().String("addr", "127.0.0.1:8081", "Listen address for the REST API")
+}
+
+type apiPage[T any] struct {
+	Total int64 `json:"total"`
+	Limit int64 `json:"limit"`
+	Offset int64 `json:"offset"`
+	Items []T `json:"items"`
+}
+
+// apiServer only ever returns documents of its tenant. With a token, every
+// request must carry it as "Authorization: Bearer <token>".
+type apiServer struct {
+	store storage
+	tenant string
+	token string
+}
+
+func runServeApiCmd(cmd *cobra.Command, args []string) error {
+	addr, _ := cmd.Flags().GetString("addr")
+	token, err := envOrFile("API_TOKEN")
+	if err != nil {
+		return err
+	}
+	if token == "" && !isLoopbackAddr(addr) {
+		slog.WarnContext(cmd.Context(), "Serving the API without authentication on a non-loopback address, set API_TOKEN",
+			"addr", addr,
+			)
+	}
+	s := &apiServer{
+		store: store,
+		tenant: tenant,
+		token: token,
+	}
+	server := &http.Server{
+		Addr: addr,
+		Handler: s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	fmt.Printf("Serving API on %s\n", addr)
+	return listenAndServe(cmd.Context(), server)
+}
+
+func (s *apiServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /users", s.listUsers)
+	mux.HandleFunc("GET /sharedboxes", s.listSharedboxes)
+	mux.HandleFunc("GET /sharedboxes/{node}", s.getSharedbox)
+	mux.HandleFunc("GET /sharedboxes/{node}/children", s.listChildren)
+	mux.HandleFunc("GET /sharedboxes/{node}/ancestors", s.listAncestors)
+	if s.token == "" {
+		return mux
+	}
+	return s.requireToken(mux)
+}
+
+func (s *apiServer) requireToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="abdsa"`)
+			writeJSONError(w, http.StatusUnauthorized, errors.New("missing or invalid bearer token"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isLoopbackAddr reports whether a listen address only accepts local
+// connections.
+func isLoopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// pageParams reads limit and offset from the query string.
+func pageParams(r *http.Request) (int64, int64, error) {
+	limit := int64(API_DEFAULT_PAGE_LIMIT)
+	offset := int64(0)
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 1 || n > API_MAX_PAGE_LIMIT {
+			return 0, 0, fmt.Errorf("limit must be between 1 and %d", API_MAX_PAGE_LIMIT)
+		}
+		limit = n
+	}
+	if v := r.URL.Query().Get("offset"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			return 0, 0, fmt.Errorf("offset must be a non-negative integer")
+		}
+		offset = n
+	}
+	return limit, offset, nil
+}
+
+func (s *apiServer) listUsers(w http.ResponseWriter, r *http.Request) {
+	limit, offset, err := pageParams(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	query := r.URL.Query()
+	filter := userFilter{
+		Query: query.Get("q"),
+		RoleName: query.Get("role_name"),
+	}
+	if v := query.Get("status"); v != "" {
+		status, err := strconv.Atoi(v)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Errorf("status must be an integer"))
+			return
+		}
+		filter.Status = &status
+	}
+	users, total, err := s.store.ListUsers(r.Context(), s.tenant, filter, limit, offset)
+	if err != nil {
+		s.internalError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, apiPage[UserDocument]{
+		Total: total,
+		Limit: limit,
+		Offset: offset,
+		Items: users,
+	})
+}
+
+func (s *apiServer) listSharedboxes(w http.ResponseWriter, r *http.Request) {
+	limit, offset, err := pageParams(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	query := r.URL.Query()
+	filter := sharedboxFilter{
+		Query: query.Get("q"),
+		DrivePathPrefix: query.Get("drive_path_prefix"),
+	}
+	if query.Has("parent_node") {
+		parent := query.Get("parent_node")
+		filter.ParentNode = &parent
+	}
+	s.writeSharedboxPage(w, r, filter, limit, offset)
+}
+
+func (s *apiServer) getSharedbox(w http.ResponseWriter, r *http.Request) {
+	item, err := s.findSharedbox(r.Context(), r.PathValue("node"))
+	if errors.Is(err, errSharedboxNotFound) {
+		writeJSONError(w, http.StatusNotFound, fmt.Errorf("sharedbox %s not found", r.PathValue("node")))
+		return
+	}
+	if err != nil {
+		s.internalError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, item)
+}
+
+func (s *apiServer) listChildren(w http.ResponseWriter, r *http.Request) {
+	limit, offset, err := pageParams(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	parent := r.PathValue("node")
+	filter := sharedboxFilter{
+		Query: r.URL.Query().Get("q"),
+		ParentNode: &parent,
+	}
+	s.writeSharedboxPage(w, r, filter, limit, offset)
+}
+
+// listAncestors returns the chain of parents of node, starting at the root.
+func (s *apiServer) listAncestors(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	item, err := s.findSharedbox(ctx, r.PathValue("node"))
+	if errors.Is(err, errSharedboxNotFound) {
+		writeJSONError(w, http.StatusNotFound, fmt.Errorf("sharedbox %s not found", r.PathValue("node")))
+		return
+	}
+	if err != nil {
+		s.internalError(w, r, err)
+		return
+	}
+	ancestors, err := findSharedboxAncestors(ctx, s.store, s.tenant, item)
+	if err != nil {
+		s.internalError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"node": item,
+		"ancestors": ancestors,
+	})
+}
+
+func (s *apiServer) findSharedbox(ctx context.Context, node string) (SharedBoxListItemWithParent, error) {
+	return s.store.FindSharedbox(ctx, s.tenant, node)
+}
+
+func (s *apiServer) writeSharedboxPage(
+	w http.ResponseWriter,
+	r *http.Request,
+	filter sharedboxFilter,
+	limit int64,
+	offset int64,
+) {
+	items, total, err := s.store.ListSharedboxes(r.Context(), s.tenant, filter, limit, offset)
+	if err != nil {
+		s.internalError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, apiPage[SharedBoxListItemWithParent]{
+		Total: total,
+		Limit: limit,
+		Offset: offset,
+		Items: items,
+	})
+}
+
+func (s *apiServer) internalError(w http.ResponseWriter, r *http.Request, err error) {
+	slog.ErrorContext(r.Context(), "API request failed",
+		"method", r.Method,
+		"path", r.URL.Path,
+		"error", err,
+		)
+	writeJSONError(w, http.StatusInternalServerError, errors.New("internal server error"))
+}
@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestApiServerToken(t *testing.T) {
+	s := &apiServer{token: "secret"}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	for _, tc := range []struct {
+		name string
+		header string
+		want int
+	}{
+		{name: "valid", header: "Bearer secret", want: http.StatusNoContent},
+		{name: "missing", want: http.StatusUnauthorized},
+		{name: "wrong", header: "Bearer secrets", want: http.StatusUnauthorized},
+		{name: "wrong scheme", header: "Basic secret", want: http.StatusUnauthorized},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/users", nil)
+			if tc.header != "" {
+				req.Header.Set("Authorization", tc.header)
+			}
+			rec := httptest.NewRecorder()
+			s.requireToken(next).ServeHTTP(rec, req)
+			if rec.Code != tc.want {
+				t.Errorf("status %d, expected %d", rec.Code, tc.want)
+			}
+		})
+	}
+}
+
+func TestIsLoopbackAddr(t *testing.T) {
+	for _, tc := range []struct {
+		addr string
+		want bool
+	}{
+		{addr: "127.0.0.1:8081", want: true},
+		{addr: "localhost:8081", want: true},
+		{addr: "[::1]:8081", want: true},
+		{addr: ":8081", want: false},
+		{addr: "0.0.0.0:8081", want: false},
+		{addr: "192.0.2.1:8081", want: false},
+		{addr: "8081", want: false},
+	} {
+		if got := isLoopbackAddr(tc.addr); got != tc.want {
+			t.Errorf("isLoopbackAddr(%q) = %v, expected %v", tc.addr, got, tc.want)
+		}
+	}
+}
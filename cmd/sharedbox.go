@@ -12,6 +12,12 @@ func init() {
 	sharedboxCmd.AddCommand(
 		sharedboxSyncCmd,
 		sharedboxExportCmd,
+		sharedboxSearchCmd,
+		sharedboxResolveCmd,
+		sharedboxPathCmd,
+		sharedboxFilesCmd,
+		sharedboxUsageCmd,
+		sharedboxPullCmd,
 		)
 }
 
@@ -0,0 +1,248 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// sharedboxCrawler walks the sharedbox tree with a fixed number of workers.
+// Every worker pops a node from the queue, lists its children and pushes them
+// back, so the number of goroutines does not depend on the size of the tree.
+// The caller seeds the queue with the root node before calling Run.
+// When fileCh is set, the files of every folder below the root are listed
+// too, by the same workers and through the same limiter. pruneFiles, when
+// set, is then called with every completely listed folder to drop the files
+// that are no longer in it.
+type sharedboxCrawler struct {
+	client     *AdminApiClient
+	tenant     string
+	limiter    rateWaiter
+	queue      nodeQueue
+	recursive  bool
+	workerSize int
+	fileCh     chan<- FileDocument
+	pruneFiles func(ctx context.Context, tenant string, parentNode string, keep []string) (int64, error)
+
+	abort context.CancelCauseFunc
+}
+
+func (c *sharedboxCrawler) Run(
+	ctx context.Context,
+	itemCh chan<- SharedBoxListItemWithParent,
+	errCh chan<- NodeError,
+) error {
+	ctx, c.abort = context.WithCancelCause(ctx)
+	defer c.abort(nil)
+	var wg sync.WaitGroup
+	for i := 0; i < c.workerSize; i++ {
+		wg.Add(1)
+		go c.worker(ctx, &wg, itemCh, errCh)
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return context.Cause(ctx)
+	}
+	return nil
+}
+
+func (c *sharedboxCrawler) worker(
+	ctx context.Context,
+	wg *sync.WaitGroup,
+	itemCh chan<- SharedBoxListItemWithParent,
+	errCh chan<- NodeError,
+) {
+	defer wg.Done()
+	for {
+		node, ok, err := c.queue.Pop(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				slog.DebugContext(ctx, "Worker context done, exiting")
+				return
+			}
+			errCh <- NodeError{
+				Err: err,
+			}
+			return
+		}
+		if !ok {
+			slog.DebugContext(ctx, "Node queue drained, exiting worker")
+			return
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		c.fetch(ctx, node, itemCh, errCh)
+		if err := c.queue.Done(ctx, node); err != nil {
+			errCh <- NodeError{
+				Node: node,
+				Err: err,
+			}
+		}
+	}
+}
+
+func (c *sharedboxCrawler) fetch(
+	ctx context.Context,
+	node string,
+	itemCh chan<- SharedBoxListItemWithParent,
+	errCh chan<- NodeError,
+) {
+	ctx, span := tracer.Start(ctx, "sharedbox.fetch",
+		trace.WithAttributes(
+			attribute.String("node", node),
+			),
+		)
+	defer span.End()
+	var resp SharedBoxListResponse
+	attempts, err := c.call(ctx, func(requestCtx context.Context) error {
+		var err error
+		resp, err = c.client.SharedboxesList(requestCtx, node)
+		return err
+	})
+	if err != nil {
+		recordSpanError(span, err)
+		c.fail(ctx, node, err, attempts, errCh)
+		return
+	}
+	span.SetAttributes(attribute.Int("child_count", len(resp.Lists)))
+	if c.fileCh != nil && node != "" {
+		c.fetchFiles(ctx, node, errCh)
+	}
+	if len(resp.Lists) == 0 {
+		return
+	}
+	slog.DebugContext(ctx, "Fetched sharedbox list",
+		"node", node,
+		"count", len(resp.Lists),
+		)
+	for _, item := range resp.Lists {
+		itemCh <- SharedBoxListItemWithParent{
+			Tenant: c.tenant,
+			Item: item,
+			ParentNode: node,
+			SchemaVersion: SHAREDBOX_SCHEMA_VERSION,
+		}
+		if c.recursive {
+			if err := c.queue.Push(ctx, item.Node); err != nil {
+				errCh <- NodeError{
+					Node: item.Node,
+					Err: err,
+				}
+			}
+		}
+	}
+}
+
+// fetchFiles lists the files directly inside node, page by page.
+func (c *sharedboxCrawler) fetchFiles(
+	ctx context.Context,
+	node string,
+	errCh chan<- NodeError,
+) {
+	ctx, span := tracer.Start(ctx, "sharedbox.fetch_files",
+		trace.WithAttributes(
+			attribute.String("node", node),
+			),
+		)
+	defer span.End()
+	var fileSeqs []string
+	for offset := 0; ; offset += DIRECTCLOUD_FILES_PAGE_SIZE {
+		var resp FileListResponse
+		attempts, err := c.call(ctx, func(requestCtx context.Context) error {
+			var err error
+			resp, err = c.client.FilesList(requestCtx, node, offset)
+			return err
+		})
+		if err != nil {
+			recordSpanError(span, err)
+			c.fail(ctx, node, err, attempts, errCh)
+			return
+		}
+		for _, item := range resp.Lists {
+			c.fileCh <- newFileDocument(c.tenant, node, item)
+			fileSeqs = append(fileSeqs, string(item.FileSeq))
+		}
+		// A page larger than requested means the endpoint ignores limit and
+		// offset and returned everything, so asking for more would loop.
+		if len(resp.Lists) != DIRECTCLOUD_FILES_PAGE_SIZE || (resp.Total > 0 && len(fileSeqs) >= resp.Total) {
+			break
+		}
+	}
+	span.SetAttributes(attribute.Int("file_count", len(fileSeqs)))
+	slog.DebugContext(ctx, "Fetched file list",
+		"node", node,
+		"count", len(fileSeqs),
+		)
+	if c.pruneFiles == nil {
+		return
+	}
+	// Files moved elsewhere are re-parented by their upsert whether it runs
+	// before or after this, so only deleted files are removed.
+	deleted, err := c.pruneFiles(ctx, c.tenant, node, fileSeqs)
+	if err != nil {
+		recordSpanError(span, err)
+		errCh <- NodeError{
+			Node: node,
+			Err: fmt.Errorf("Failed to remove stale files: %w", err),
+		}
+		return
+	}
+	if deleted > 0 {
+		slog.DebugContext(ctx, "Removed stale files",
+			"node", node,
+			"count", deleted,
+			)
+	}
+}
+
+// call waits for the rate limiter and runs request, retrying transient
+// failures.
+func (c *sharedboxCrawler) call(
+	ctx context.Context,
+	request func(ctx context.Context) error,
+) (int, error) {
+	return withAPIRetry(ctx, func() error {
+		requestCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+		defer cancel()
+		waitCtx, waitSpan := tracer.Start(requestCtx, "rate_limiter.wait")
+		waitStart := time.Now()
+		err := c.limiter.Wait(waitCtx)
+		rateLimiterWait.Observe(time.Since(waitStart).Seconds())
+		recordSpanError(waitSpan, err)
+		waitSpan.End()
+		if err != nil {
+			return err
+		}
+		return request(requestCtx)
+	})
+}
+
+func (c *sharedboxCrawler) fail(
+	ctx context.Context,
+	node string,
+	err error,
+	attempts int,
+	errCh chan<- NodeError,
+) {
+	switch {
+	case isAuthError(err):
+		// Every other node would fail the same way.
+		c.abort(fmt.Errorf("Aborting sync, DirectCloud rejected the admin token: %w", err))
+	case isPermissionError(err):
+		slog.WarnContext(ctx, "Permission denied for sharedbox node, skipping it",
+			"node", node,
+			"error", err,
+			)
+	}
+	errCh <- NodeError{
+		Node: node,
+		Err: err,
+		Attempt: attempts,
+	}
+}
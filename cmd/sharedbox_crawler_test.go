@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"context"
+	"net/http"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// BenchmarkSharedboxCrawl crawls a synthetic tree served by mockDirectCloud
+// and reports throughput together with peak goroutine count, heap size and
+// queue length, so changes to the crawler can be compared without touching
+// the real API.
+//
+//	go test ./cmd -run '^$' -bench SharedboxCrawl -benchtime 3x
+func BenchmarkSharedboxCrawl(b *testing.B) {
+	mock := &mockDirectCloud{
+		Depth: 4,
+		Fanout: 10,
+		Latency: time.Millisecond,
+	}
+	server := mock.Start()
+	defer server.Close()
+
+	for _, bc := range []struct {
+		name string
+		spill string
+		queueSize int
+	}{
+		// Large enough for the whole frontier, as --spill none requires.
+		{name: "spill=none", spill: NODE_QUEUE_SPILL_NONE, queueSize: mock.NodeCount()},
+		// Small enough that most of the frontier goes through the spill file.
+		{name: "spill=disk", spill: NODE_QUEUE_SPILL_DISK, queueSize: 100},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			for b.Loop() {
+				benchmarkSharedboxCrawl(b, mock, server.URL, bc.spill, bc.queueSize)
+			}
+		})
+	}
+}
+
+func benchmarkSharedboxCrawl(b *testing.B, mock *mockDirectCloud, baseURL string, spillKind string, queueSize int) {
+	ctx := context.Background()
+	const workers = 20
+	spill, err := newNodeSpill(spillKind, b.TempDir(), "")
+	if err != nil {
+		b.Fatal(err)
+	}
+	queue := newMemoryNodeQueue(queueSize, spill)
+	defer queue.Close()
+
+	client := NewAdminApiClient("mock")
+	client.BaseURL = baseURL
+	client.httpClient.Transport = &instrumentedTransport{
+		next: &http.Transport{
+			MaxIdleConnsPerHost: workers,
+		},
+	}
+	crawler := &sharedboxCrawler{
+		client: client,
+		limiter: rate.NewLimiter(rate.Inf, 1),
+		queue: queue,
+		recursive: true,
+		workerSize: workers,
+	}
+	if err := queue.Push(ctx, ""); err != nil {
+		b.Fatal(err)
+	}
+	itemCh := make(chan SharedBoxListItemWithParent, 1000)
+	errCh := make(chan NodeError, workers*2)
+	var (
+		drainWg sync.WaitGroup
+		items int
+		errs []NodeError
+	)
+	drainWg.Add(2)
+	go func() {
+		defer drainWg.Done()
+		for range itemCh {
+			items++
+		}
+	}()
+	go func() {
+		defer drainWg.Done()
+		for e := range errCh {
+			errs = append(errs, e)
+		}
+	}()
+
+	var (
+		peakGoroutines int
+		peakHeap uint64
+		peakQueue int
+	)
+	sampleCtx, sampleCancel := context.WithCancel(ctx)
+	sampleDone := make(chan struct{})
+	go func() {
+		defer close(sampleDone)
+		ticker := time.NewTicker(50 * time.Millisecond)
+		defer ticker.Stop()
+		var m runtime.MemStats
+		for {
+			peakGoroutines = max(peakGoroutines, runtime.NumGoroutine())
+			runtime.ReadMemStats(&m)
+			peakHeap = max(peakHeap, m.HeapInuse)
+			peakQueue = max(peakQueue, queue.Len())
+			select {
+			case <-sampleCtx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	start := time.Now()
+	crawlErr := crawler.Run(ctx, itemCh, errCh)
+	elapsed := time.Since(start)
+	close(itemCh)
+	close(errCh)
+	drainWg.Wait()
+	sampleCancel()
+	<-sampleDone
+	if crawlErr != nil {
+		b.Fatal(crawlErr)
+	}
+	if len(errs) > 0 {
+		b.Fatalf("%d nodes failed, first: %v", len(errs), errs[0].Err)
+	}
+	if items != mock.NodeCount() {
+		b.Fatalf("crawled %d nodes, expected %d", items, mock.NodeCount())
+	}
+	b.ReportMetric(float64(items)/elapsed.Seconds(), "nodes/s")
+	b.ReportMetric(float64(peakQueue), "peak-queue")
+	b.ReportMetric(float64(peakGoroutines), "peak-goroutines")
+	b.ReportMetric(float64(peakHeap)/(1<<20), "peak-heap-MiB")
+}
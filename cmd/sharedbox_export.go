@@ -11,18 +11,18 @@ import (
 
 	"github.com/briandowns/spinner"
 	"github.com/spf13/cobra"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 var sharedboxExportCmd = &cobra.Command{
 	Use: "export",
+	Annotations: withBackends(BACKEND_STORAGE),
 	RunE: runSharedboxExportCmd,
 }
 
 func init() {
 	sharedboxExportCmd.Flags().StringSlice("excludes", []string{}, "RootNode has this prefix will be excluded. Comma separated for multiple values.")
 	sharedboxExportCmd.Flags().StringSlice("includes", []string{}, "RootNode contains this keyword will be included.")
+	sharedboxExportCmd.Flags().String("dir", DIRECTORY_DEFAULT_EXPORT, "Directory to write the export file to (env EXPORT_DIR)")
 }
 
 func runSharedboxExportCmd(cmd *cobra.Command, args []string) error {
@@ -36,6 +36,10 @@ func runSharedboxExportCmd(cmd *cobra.Command, args []string) error {
 			excludes = append(excludes, subs...)
 		}
 	}
+	defaultIncludes := os.Getenv("DEFAULT_EXPORT_INCLUDES")
+	if defaultIncludes != "" && len(includes) == 0 {
+		includes = strings.Split(defaultIncludes, ",")
+	}
 	slog.DebugContext(cmdCtx, "Starting sharedbox export command",
 		"excludes", excludes,
 		"includes", includes,
@@ -46,23 +50,15 @@ func runSharedboxExportCmd(cmd *cobra.Command, args []string) error {
 	s.Suffix = " Exporting sharedboxes..."
 	s.Start()
 
-	collection := mongoClient.Database(mongoDatabase).Collection(MONGO_COLLECTION_SHAREDBOXES)
-
-	cursor, err := collection.Find(
-		cmdCtx,
-		bson.D{},
-		options.Find().SetSort(bson.D{
-			{Key: "parent_node", Value: 1},
-		}),
-		)
+	cursor, err := store.SharedboxCursor(cmdCtx, tenant)
 	if err != nil {
-		slog.ErrorContext(cmdCtx, "Failed to create MongoDB cursor for sharedboxes",
+		slog.ErrorContext(cmdCtx, "Failed to create cursor for sharedboxes",
 			"error", err)
 		return err
 	}
 	defer cursor.Close(cmdCtx)
 
-	dir := DIRECTORY_DEFAULT_EXPORT // or get from flag
+	dir := flagOrEnv(cmd, "dir", "EXPORT_DIR")
 	if err := os.Mkdir(dir, 0755); err != nil {
 		if !os.IsExist(err) {
 			slog.ErrorContext(cmdCtx, "Failed to create export directory",
@@ -72,7 +68,7 @@ func runSharedboxExportCmd(cmd *cobra.Command, args []string) error {
 	}
 	now := time.Now().In(time.Local)
 	f, err := os.OpenFile(
-		filepath.Join(dir, fmt.Sprintf("sharedbox_%s.csv", now.Format(time.DateTime))),
+		filepath.Join(dir, fmt.Sprintf("sharedbox_%s_%s.csv", tenant, now.Format(time.DateTime))),
 		os.O_CREATE | os.O_WRONLY | os.O_TRUNC,
 		0644,
 		)
@@ -107,9 +103,9 @@ func runSharedboxExportCmd(cmd *cobra.Command, args []string) error {
 	}
 
 	for cursor.Next(cmdCtx) {
-		var result SharedBoxListItemWithParent
-		if err := cursor.Decode(&result); err != nil {
-			slog.ErrorContext(cmdCtx, "Failed to decode MongoDB document",)
+		result, err := cursor.Item()
+		if err != nil {
+			slog.ErrorContext(cmdCtx, "Failed to decode sharedbox",)
 			continue
 		}
 
@@ -156,6 +152,11 @@ func runSharedboxExportCmd(cmd *cobra.Command, args []string) error {
 			continue
 		}
 	}
+	if err := cursor.Err(); err != nil {
+		slog.ErrorContext(cmdCtx, "Failed to read sharedboxes",
+			"error", err)
+		return err
+	}
 
 	w.Flush()
 	if err := w.Error(); err != nil {
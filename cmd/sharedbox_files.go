@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/spf13/cobra"
+)
+
+var sharedboxFilesCmd = &cobra.Command{
+	Use: "files",
+}
+
+var sharedboxFilesSyncCmd = &cobra.Command{
+	Use: "sync",
+	Annotations: withBackends(BACKEND_STORAGE_WRITE),
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := initAdminApiClient(); err != nil {
+			return fmt.Errorf("Failed to initialize Admin API client: %v", err)
+		}
+		slog.DebugContext(cmd.Context(), "Initialized Admin API client")
+		return nil
+	},
+	RunE: runSharedboxFilesSyncCmd,
+}
+
+func init() {
+	addSharedboxSyncFlags(sharedboxFilesSyncCmd)
+	sharedboxFilesCmd.AddCommand(
+		sharedboxFilesSyncCmd,
+		)
+}
+
+// runSharedboxFilesSyncCmd crawls the folders like `sharedbox sync` and
+// lists the files of each one on the way.
+func runSharedboxFilesSyncCmd(cmd *cobra.Command, args []string) error {
+	opts, err := sharedboxSyncOptionsFromFlags(cmd)
+	if err != nil {
+		return err
+	}
+	opts.Files = true
+	return runSharedboxSync(cmd.Context(), adminApiClient, opts)
+}
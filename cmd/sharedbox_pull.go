@@ -0,0 +1,484 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/cobra"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/time/rate"
+)
+
+const (
+	PULL_PART_SUFFIX = ".part"
+	// PULL_QUEUE_SIZE bounds pending folders; a pull is expected to cover
+	// one project folder, not the whole tenant.
+	PULL_QUEUE_SIZE = 100000
+)
+
+// sharedboxPullCmd declares no backend; Redis is only needed for
+// --distributed.
+var sharedboxPullCmd = &cobra.Command{
+	Use: "pull",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := initAdminApiClient(); err != nil {
+			return fmt.Errorf("Failed to initialize Admin API client: %v", err)
+		}
+		return nil
+	},
+	RunE: runSharedboxPullCmd,
+}
+
+func init() {
+	sharedboxPullCmd.Flags().String("node", "", "Node of the folder to download")
+	sharedboxPullCmd.Flags().String("dest", "", "Local directory to recreate the folder in")
+	sharedboxPullCmd.Flags().Int("workers", 4, "Number of folders listed and files downloaded at the same time")
+	sharedboxPullCmd.Flags().Float64("rate", 40, "Requests per second for listing and downloads together")
+	sharedboxPullCmd.Flags().Bool("distributed", false, "Share the rate limit with sync processes through Redis (uses --global-rate)")
+	sharedboxPullCmd.Flags().Float64("global-rate", 40, "Requests per second shared by all distributed processes")
+	sharedboxPullCmd.MarkFlagRequired("node")
+	sharedboxPullCmd.MarkFlagRequired("dest")
+}
+
+type pullStats struct {
+	Downloaded atomic.Int64
+	Resumed atomic.Int64
+	Skipped atomic.Int64
+	Failed atomic.Int64
+	Bytes atomic.Int64
+}
+
+func runSharedboxPullCmd(cmd *cobra.Command, args []string) error {
+	cmdCtx := cmd.Context()
+	rootNode, _ := cmd.Flags().GetString("node")
+	dest, _ := cmd.Flags().GetString("dest")
+	workers, _ := cmd.Flags().GetInt("workers")
+	if workers < 1 {
+		return fmt.Errorf("--workers must be positive")
+	}
+	var limiter rateWaiter
+	if distributed, _ := cmd.Flags().GetBool("distributed"); distributed {
+		if err := initRedisClient(cmd); err != nil {
+			return err
+		}
+		globalRate, _ := cmd.Flags().GetFloat64("global-rate")
+		redisLimiter, err := newRedisRateLimiter(fmt.Sprintf("directcloud:%s:admin", tenant), globalRate)
+		if err != nil {
+			return err
+		}
+		limiter = redisLimiter
+	} else {
+		reqPerSec, _ := cmd.Flags().GetFloat64("rate")
+		limiter = rate.NewLimiter(rate.Limit(reqPerSec), 1)
+	}
+
+	folders, files, err := listPullTree(cmdCtx, adminApiClient, limiter, rootNode, workers)
+	if err != nil {
+		return err
+	}
+	dirs, err := pullDirectories(rootNode, folders)
+	if err != nil {
+		return err
+	}
+	targets, err := pullTargets(dirs, files)
+	if err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		if err := os.MkdirAll(filepath.Join(dest, dir), 0755); err != nil {
+			return fmt.Errorf("Failed to create directory: %w", err)
+		}
+	}
+	slog.InfoContext(cmdCtx, "Listed sharedbox subtree",
+		"node", rootNode,
+		"folders", len(folders),
+		"files", len(files),
+		)
+
+	var stats pullStats
+	pullCtx, abort := context.WithCancelCause(cmdCtx)
+	defer abort(nil)
+	fileCh := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range fileCh {
+				file := files[i]
+				target := filepath.Join(dest, targets[i])
+				err := pullFile(pullCtx, adminApiClient, limiter, file, target, &stats)
+				if err == nil {
+					continue
+				}
+				stats.Failed.Add(1)
+				slog.ErrorContext(pullCtx, "Failed to download file",
+					"node", file.ParentNode,
+					"fileSeq", file.FileSeq,
+					"path", target,
+					"error", err,
+					)
+				if isAuthError(err) {
+					// Every other file would fail the same way.
+					abort(fmt.Errorf("Aborting pull, DirectCloud rejected the admin token: %w", err))
+				}
+			}
+		}()
+	}
+feed:
+	for i := range files {
+		select {
+		case fileCh <- i:
+		case <-pullCtx.Done():
+			break feed
+		}
+	}
+	close(fileCh)
+	wg.Wait()
+
+	fmt.Printf("downloaded: %d (resumed %d), skipped: %d, failed: %d, %s transferred\n",
+		stats.Downloaded.Load(),
+		stats.Resumed.Load(),
+		stats.Skipped.Load(),
+		stats.Failed.Load(),
+		formatBytes(stats.Bytes.Load()),
+		)
+	if pullCtx.Err() != nil {
+		return context.Cause(pullCtx)
+	}
+	if n := stats.Failed.Load(); n > 0 {
+		return fmt.Errorf("%d files failed to download, run the pull again to retry them", n)
+	}
+	return nil
+}
+
+// listPullTree lists every folder and file below rootNode with the sync
+// crawler. Listing finishes before downloading starts, so every folder is
+// known when its files are placed.
+func listPullTree(
+	ctx context.Context,
+	client *AdminApiClient,
+	limiter rateWaiter,
+	rootNode string,
+	workers int,
+) ([]SharedBoxListItemWithParent, []FileDocument, error) {
+	queue := newMemoryNodeQueue(PULL_QUEUE_SIZE, nil)
+	defer queue.Close()
+	if err := queue.Push(ctx, rootNode); err != nil {
+		return nil, nil, err
+	}
+	itemCh := make(chan SharedBoxListItemWithParent, 1000)
+	fileCh := make(chan FileDocument, 1000)
+	errCh := make(chan NodeError, workers*2)
+	crawler := &sharedboxCrawler{
+		client: client,
+		tenant: tenant,
+		limiter: limiter,
+		queue: queue,
+		recursive: true,
+		workerSize: workers,
+		fileCh: fileCh,
+	}
+	var (
+		wg sync.WaitGroup
+		folders []SharedBoxListItemWithParent
+		files []FileDocument
+		listErr error
+	)
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		for item := range itemCh {
+			folders = append(folders, item)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for file := range fileCh {
+			files = append(files, file)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for nodeErr := range errCh {
+			slog.ErrorContext(ctx, "Failed to list sharedbox node",
+				"node", nodeErr.Node,
+				"error", nodeErr.Err,
+				)
+			listErr = errors.Join(listErr, fmt.Errorf("node %s: %w", nodeErr.Node, nodeErr.Err))
+		}
+	}()
+	crawlErr := crawler.Run(ctx, itemCh, errCh)
+	close(itemCh)
+	close(fileCh)
+	close(errCh)
+	wg.Wait()
+	if crawlErr != nil {
+		return nil, nil, crawlErr
+	}
+	// A partial listing would make the local copy look complete.
+	if listErr != nil {
+		return nil, nil, fmt.Errorf("Failed to list the sharedbox subtree: %w", listErr)
+	}
+	return folders, files, nil
+}
+
+// pullDirectories maps every node to its directory relative to the
+// destination, built from folder names so that it does not depend on how the
+// root's drive path is spelled. Sibling folders whose names end up the same
+// locally all get their node appended, so that the result does not depend on
+// the order they were listed in.
+func pullDirectories(rootNode string, folders []SharedBoxListItemWithParent) (map[string]string, error) {
+	byNode := make(map[string]SharedBoxListItemWithParent, len(folders))
+	siblings := map[[2]string]int{}
+	for _, f := range folders {
+		byNode[f.Item.Node] = f
+		siblings[[2]string{f.ParentNode, localNameKey(localFileName(f.Item.Name))}]++
+	}
+	dirs := map[string]string{rootNode: "."}
+	var resolve func(node string, depth int) (string, error)
+	resolve = func(node string, depth int) (string, error) {
+		if dir, ok := dirs[node]; ok {
+			return dir, nil
+		}
+		folder, ok := byNode[node]
+		if !ok || depth > API_MAX_ANCESTOR_DEPTH {
+			return "", fmt.Errorf("Folder %s is not below %s", node, rootNode)
+		}
+		parent, err := resolve(folder.ParentNode, depth+1)
+		if err != nil {
+			return "", err
+		}
+		name := localFileName(folder.Item.Name)
+		if siblings[[2]string{folder.ParentNode, localNameKey(name)}] > 1 {
+			name = fmt.Sprintf("%s (%s)", name, localFileName(node))
+		}
+		dir := filepath.Join(parent, name)
+		dirs[node] = dir
+		return dir, nil
+	}
+	for _, f := range folders {
+		if _, err := resolve(f.Item.Node, 0); err != nil {
+			return nil, err
+		}
+	}
+	return dirs, nil
+}
+
+// pullTargets returns the path of every file relative to the destination.
+// Files of a folder whose names end up the same locally, or the same as a
+// subfolder or the .part file of a sibling, get their file_seq appended
+// before the extension, so that no two downloads share a target or .part
+// file.
+func pullTargets(dirs map[string]string, files []FileDocument) ([]string, error) {
+	taken := make(map[string]bool, len(dirs))
+	for _, dir := range dirs {
+		taken[localNameKey(dir)] = true
+	}
+	paths := make([]string, len(files))
+	count := map[string]int{}
+	for i, file := range files {
+		dir, ok := dirs[file.ParentNode]
+		if !ok {
+			return nil, fmt.Errorf("Folder %s of file %s was not listed", file.ParentNode, file.FileSeq)
+		}
+		paths[i] = filepath.Join(dir, localFileName(file.Name))
+		count[localNameKey(paths[i])]++
+	}
+	for _, p := range paths {
+		taken[localNameKey(p+PULL_PART_SUFFIX)] = true
+	}
+	for i, file := range files {
+		key := localNameKey(paths[i])
+		if count[key] == 1 && !taken[key] {
+			continue
+		}
+		ext := filepath.Ext(paths[i])
+		paths[i] = fmt.Sprintf("%s (%s)%s", strings.TrimSuffix(paths[i], ext), localFileName(string(file.FileSeq)), ext)
+	}
+	seen := make(map[string]bool, len(paths)*2)
+	for _, dir := range dirs {
+		seen[localNameKey(dir)] = true
+	}
+	for i, p := range paths {
+		key := localNameKey(p)
+		partKey := localNameKey(p + PULL_PART_SUFFIX)
+		if seen[key] || seen[partKey] {
+			return nil, fmt.Errorf("File %s would be saved as %s, which another file or folder already uses", files[i].FileSeq, p)
+		}
+		seen[key] = true
+		seen[partKey] = true
+	}
+	return paths, nil
+}
+
+// localNameKey compares local paths case-insensitively, as the destination
+// may be on a case-insensitive file system.
+func localNameKey(p string) string {
+	return strings.ToLower(p)
+}
+
+// localFileName makes a DirectCloud name safe to use as one path element.
+func localFileName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == 0 {
+			return '_'
+		}
+		return r
+	}, name)
+	if name == "" || name == "." || name == ".." {
+		return "_" + name
+	}
+	return name
+}
+
+// pullFile downloads file to target unless a copy with the same size and
+// modification time is already there. The download goes to target.part
+// first and resumes from its size with a Range request.
+func pullFile(
+	ctx context.Context,
+	client *AdminApiClient,
+	limiter rateWaiter,
+	file FileDocument,
+	target string,
+	stats *pullStats,
+) error {
+	size := int64(file.Size)
+	if info, err := os.Stat(target); err == nil && info.Size() == size &&
+		(file.ModifiedAt.IsZero() || info.ModTime().Equal(file.ModifiedAt)) {
+		stats.Skipped.Add(1)
+		return nil
+	}
+	ctx, span := tracer.Start(ctx, "sharedbox.pull_file",
+		trace.WithAttributes(
+			attribute.String("node", file.ParentNode),
+			attribute.String("file_seq", string(file.FileSeq)),
+			attribute.Int64("size", size),
+			),
+		)
+	defer span.End()
+	part := target + PULL_PART_SUFFIX
+	resumed := false
+	_, err := withAPIRetry(ctx, func() error {
+		if err := limiter.Wait(ctx); err != nil {
+			return err
+		}
+		offset, err := partSize(part, size)
+		if err != nil {
+			return err
+		}
+		if offset > 0 && offset == size {
+			return nil
+		}
+		resp, err := client.FileDownload(ctx, file.ParentNode, string(file.FileSeq), offset)
+		var apiErr *APIError
+		if offset > 0 && errors.As(err, &apiErr) && apiErr.HTTPStatus == http.StatusRequestedRangeNotSatisfiable {
+			// The file changed since the partial download; start over.
+			if err := os.Remove(part); err != nil {
+				return err
+			}
+			offset = 0
+			if err := limiter.Wait(ctx); err != nil {
+				return err
+			}
+			resp, err = client.FileDownload(ctx, file.ParentNode, string(file.FileSeq), 0)
+		}
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		flags := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+		if resp.StatusCode == http.StatusPartialContent && offset > 0 {
+			resumed = true
+		} else {
+			// The server ignored the Range header.
+			flags |= os.O_TRUNC
+		}
+		f, err := os.OpenFile(part, flags, 0644)
+		if err != nil {
+			return err
+		}
+		body := &readErrorReader{r: resp.Body}
+		n, copyErr := io.Copy(f, body)
+		stats.Bytes.Add(n)
+		if err := f.Close(); copyErr == nil {
+			copyErr = err
+		}
+		if body.err != nil {
+			// Keep the partial file to resume from; a dropped connection
+			// is worth retrying.
+			return &url.Error{Op: "Get", URL: resp.Request.URL.String(), Err: body.err}
+		}
+		// Write errors such as a full disk are not the API's fault and are
+		// not retried.
+		return copyErr
+	})
+	if err != nil {
+		recordSpanError(span, err)
+		return err
+	}
+	info, err := os.Stat(part)
+	if err != nil {
+		return err
+	}
+	if info.Size() != size {
+		os.Remove(part)
+		return fmt.Errorf("Downloaded %d bytes, expected %d", info.Size(), size)
+	}
+	if err := os.Rename(part, target); err != nil {
+		return err
+	}
+	if !file.ModifiedAt.IsZero() {
+		if err := os.Chtimes(target, time.Time{}, file.ModifiedAt); err != nil {
+			return err
+		}
+	}
+	stats.Downloaded.Add(1)
+	if resumed {
+		stats.Resumed.Add(1)
+	}
+	return nil
+}
+
+// readErrorReader remembers the error of the last failed Read, so that a
+// failed copy can be told apart from a failed write.
+type readErrorReader struct {
+	r io.Reader
+	err error
+}
+
+func (r *readErrorReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	return n, err
+}
+
+// partSize returns how much of a download is already in part. A part larger
+// than the file cannot be resumed and is removed.
+func partSize(part string, size int64) (int64, error) {
+	info, err := os.Stat(part)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if info.Size() > size {
+		return 0, os.Remove(part)
+	}
+	return info.Size(), nil
+}
@@ -0,0 +1,160 @@
+package cmd
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestPullDirectories(t *testing.T) {
+	folder := func(node, parent, name string) SharedBoxListItemWithParent {
+		return SharedBoxListItemWithParent{
+			Item: SharedBoxListItem{Node: node, Name: name},
+			ParentNode: parent,
+		}
+	}
+	for _, tc := range []struct {
+		name string
+		folders []SharedBoxListItemWithParent
+		want map[string]string
+		wantErr bool
+	}{
+		{
+			name: "nested",
+			folders: []SharedBoxListItemWithParent{
+				folder("b", "a", "Child"),
+				folder("a", "root", "Parent"),
+			},
+			want: map[string]string{
+				"root": ".",
+				"a": "Parent",
+				"b": filepath.Join("Parent", "Child"),
+			},
+		},
+		{
+			name: "siblings differing in case",
+			folders: []SharedBoxListItemWithParent{
+				folder("a", "root", "Docs"),
+				folder("b", "root", "docs"),
+				folder("c", "a", "docs"),
+			},
+			want: map[string]string{
+				"root": ".",
+				"a": "Docs (a)",
+				"b": "docs (b)",
+				"c": filepath.Join("Docs (a)", "docs"),
+			},
+		},
+		{
+			name: "unsafe names",
+			folders: []SharedBoxListItemWithParent{
+				folder("a", "root", "a/b"),
+				folder("b", "root", ".."),
+			},
+			want: map[string]string{
+				"root": ".",
+				"a": "a_b",
+				"b": "_..",
+			},
+		},
+		{
+			name: "not below the root",
+			folders: []SharedBoxListItemWithParent{
+				folder("a", "elsewhere", "Orphan"),
+			},
+			wantErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := pullDirectories("root", tc.folders)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tc.want) {
+				t.Fatalf("got %v, expected %v", got, tc.want)
+			}
+			for node, dir := range tc.want {
+				if got[node] != dir {
+					t.Errorf("directory of %s = %q, expected %q", node, got[node], dir)
+				}
+			}
+		})
+	}
+}
+
+func TestPullTargets(t *testing.T) {
+	dirs := map[string]string{"root": ".", "sub": "sub"}
+	file := func(seq, parent, name string) FileDocument {
+		return FileDocument{
+			FileListItem: FileListItem{FileSeq: apiString(seq), Name: name},
+			ParentNode: parent,
+		}
+	}
+	for _, tc := range []struct {
+		name string
+		files []FileDocument
+		want []string
+		wantErr bool
+	}{
+		{
+			name: "distinct",
+			files: []FileDocument{
+				file("1", "root", "a.txt"),
+				file("2", "sub", "a.txt"),
+			},
+			want: []string{"a.txt", filepath.Join("sub", "a.txt")},
+		},
+		{
+			name: "same name in one folder",
+			files: []FileDocument{
+				file("1", "root", "a.txt"),
+				file("2", "root", "A.txt"),
+			},
+			want: []string{"a (1).txt", "A (2).txt"},
+		},
+		{
+			name: "same name as a subfolder",
+			files: []FileDocument{
+				file("1", "root", "Sub"),
+			},
+			want: []string{"Sub (1)"},
+		},
+		{
+			name: "same name as the part file of a sibling",
+			files: []FileDocument{
+				file("1", "root", "a.txt"),
+				file("2", "root", "a.txt"+PULL_PART_SUFFIX),
+			},
+			want: []string{"a.txt", "a.txt (2)" + PULL_PART_SUFFIX},
+		},
+		{
+			name: "folder not listed",
+			files: []FileDocument{
+				file("1", "gone", "a.txt"),
+			},
+			wantErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := pullTargets(dirs, tc.files)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("got %q, expected %q", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,209 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+// Neither command declares a backend: they read the storage backend, or
+// with --live only the API, so that a stale or unreachable mirror does not
+// get in the way of a live lookup.
+var sharedboxResolveCmd = &cobra.Command{
+	Use: "resolve <path>",
+	Args: cobra.ExactArgs(1),
+	RunE: runSharedboxResolveCmd,
+}
+
+var sharedboxPathCmd = &cobra.Command{
+	Use: "path <node>",
+	Args: cobra.ExactArgs(1),
+	RunE: runSharedboxPathCmd,
+}
+
+func init() {
+	for _, c := range []*cobra.Command{sharedboxResolveCmd, sharedboxPathCmd} {
+		c.Flags().Bool("live", false, "Look the node up through the DirectCloud API instead of the mirror")
+		c.Flags().Bool("json", false, "Print the node and its ancestors as JSON")
+	}
+}
+
+// resolvedSharedbox is a node with its ancestors, root first, in the same
+// shape as the ancestors endpoint of `serve api`.
+type resolvedSharedbox struct {
+	Node SharedBoxListItemWithParent `json:"node"`
+	Ancestors []SharedBoxListItemWithParent `json:"ancestors"`
+}
+
+func runSharedboxResolveCmd(cmd *cobra.Command, args []string) error {
+	cmdCtx := cmd.Context()
+	live, _ := cmd.Flags().GetBool("live")
+	drivePath := cleanDrivePath(args[0])
+	var resolved resolvedSharedbox
+	if live {
+		client, err := loadAdminApiClient()
+		if err != nil {
+			return err
+		}
+		resolved, err = resolveSharedboxLive(cmdCtx, client, drivePath)
+		if err != nil {
+			return err
+		}
+	} else {
+		if err := initStorage(cmd); err != nil {
+			return err
+		}
+		item, err := store.FindSharedboxByPath(cmdCtx, tenant, drivePath)
+		if errors.Is(err, errSharedboxNotFound) {
+			return fmt.Errorf("%s not found in the mirror, run `sharedbox sync` or retry with --live", drivePath)
+		}
+		if err != nil {
+			return fmt.Errorf("Failed to look up %s: %w", drivePath, err)
+		}
+		resolved, err = resolveSharedboxMirror(cmdCtx, item)
+		if err != nil {
+			return err
+		}
+	}
+	return printResolvedSharedbox(cmd, resolved)
+}
+
+func runSharedboxPathCmd(cmd *cobra.Command, args []string) error {
+	cmdCtx := cmd.Context()
+	live, _ := cmd.Flags().GetBool("live")
+	node := args[0]
+	var resolved resolvedSharedbox
+	if live {
+		client, err := loadAdminApiClient()
+		if err != nil {
+			return err
+		}
+		resolved, err = pathSharedboxLive(cmdCtx, client, node)
+		if err != nil {
+			return err
+		}
+	} else {
+		if err := initStorage(cmd); err != nil {
+			return err
+		}
+		item, err := store.FindSharedbox(cmdCtx, tenant, node)
+		if errors.Is(err, errSharedboxNotFound) {
+			return fmt.Errorf("Node %s not found in the mirror, run `sharedbox sync` or retry with --live", node)
+		}
+		if err != nil {
+			return fmt.Errorf("Failed to look up node %s: %w", node, err)
+		}
+		resolved, err = resolveSharedboxMirror(cmdCtx, item)
+		if err != nil {
+			return err
+		}
+	}
+	if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+		return printResolvedSharedbox(cmd, resolved)
+	}
+	fmt.Println(resolved.Node.Item.DrivePath)
+	return nil
+}
+
+func resolveSharedboxMirror(ctx context.Context, item SharedBoxListItemWithParent) (resolvedSharedbox, error) {
+	ancestors, err := findSharedboxAncestors(ctx, store, tenant, item)
+	if err != nil {
+		return resolvedSharedbox{}, err
+	}
+	return resolvedSharedbox{
+		Node: item,
+		Ancestors: ancestors,
+	}, nil
+}
+
+// resolveSharedboxLive walks down from the root, listing only the folders
+// on the way to drivePath.
+func resolveSharedboxLive(ctx context.Context, client *AdminApiClient, drivePath string) (resolvedSharedbox, error) {
+	resolved := resolvedSharedbox{
+		Ancestors: []SharedBoxListItemWithParent{},
+	}
+	node := ""
+	for depth := 0; depth < API_MAX_ANCESTOR_DEPTH; depth++ {
+		var resp SharedBoxListResponse
+		_, err := withAPIRetry(ctx, func() error {
+			var err error
+			resp, err = client.SharedboxesList(ctx, node)
+			return err
+		})
+		if err != nil {
+			return resolved, fmt.Errorf("Failed to list sharedbox %q: %w", node, err)
+		}
+		var next *SharedBoxListItem
+		for i, child := range resp.Lists {
+			childPath := cleanDrivePath(child.DrivePath)
+			if childPath == drivePath {
+				resolved.Node = SharedBoxListItemWithParent{
+					Tenant: tenant,
+					Item: child,
+					ParentNode: node,
+				}
+				return resolved, nil
+			}
+			if strings.HasPrefix(drivePath, childPath+"/") {
+				next = &resp.Lists[i]
+			}
+		}
+		if next == nil {
+			break
+		}
+		resolved.Ancestors = append(resolved.Ancestors, SharedBoxListItemWithParent{
+			Tenant: tenant,
+			Item: *next,
+			ParentNode: node,
+		})
+		node = next.Node
+	}
+	return resolved, fmt.Errorf("%s not found", drivePath)
+}
+
+// pathSharedboxLive finds the path of node. The API cannot look a node up
+// by ID, so the path is taken from one of its children and then resolved
+// from the root to fill in the URL and the ancestors.
+func pathSharedboxLive(ctx context.Context, client *AdminApiClient, node string) (resolvedSharedbox, error) {
+	var resp SharedBoxListResponse
+	_, err := withAPIRetry(ctx, func() error {
+		var err error
+		resp, err = client.SharedboxesList(ctx, node)
+		return err
+	})
+	if err != nil {
+		return resolvedSharedbox{}, fmt.Errorf("Failed to list node %s: %w", node, err)
+	}
+	if len(resp.Lists) == 0 {
+		return resolvedSharedbox{}, fmt.Errorf("Node %s has no subfolders, so the API cannot tell its path; look it up in the mirror instead", node)
+	}
+	resolved, err := resolveSharedboxLive(ctx, client, path.Dir(cleanDrivePath(resp.Lists[0].DrivePath)))
+	if err != nil {
+		return resolved, err
+	}
+	if resolved.Node.Item.Node != node {
+		return resolved, fmt.Errorf("Path %s resolved to node %s, not %s", resolved.Node.Item.DrivePath, resolved.Node.Item.Node, node)
+	}
+	return resolved, nil
+}
+
+func printResolvedSharedbox(cmd *cobra.Command, resolved resolvedSharedbox) error {
+	if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(resolved)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NODE\tPATH\tURL")
+	for _, item := range append(resolved.Ancestors, resolved.Node) {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", item.Item.Node, item.Item.DrivePath, item.Item.URL)
+	}
+	return w.Flush()
+}
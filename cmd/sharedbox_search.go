@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+// SEARCH_MIN_OVERLAP is the share of query bigrams a folder must contain to
+// be listed, which lets a query with a typo or a variant spelling still match.
+const SEARCH_MIN_OVERLAP = 0.5
+
+var sharedboxSearchCmd = &cobra.Command{
+	Use: "search <query>",
+	Args: cobra.MinimumNArgs(1),
+	Annotations: withBackends(BACKEND_STORAGE),
+	RunE: runSharedboxSearchCmd,
+}
+
+func init() {
+	sharedboxSearchCmd.Flags().Int("limit", 20, "Maximum number of results, 0 for all")
+	sharedboxSearchCmd.Flags().Bool("json", false, "Print the results as JSON")
+}
+
+type sharedboxSearchResult struct {
+	Score float64 `json:"score"`
+	Node string `json:"node"`
+	Name string `json:"name"`
+	DrivePath string `json:"drive_path"`
+	URL string `json:"url"`
+	ParentNode string `json:"parent_node"`
+}
+
+func runSharedboxSearchCmd(cmd *cobra.Command, args []string) error {
+	cmdCtx := cmd.Context()
+	limit, _ := cmd.Flags().GetInt("limit")
+	asJSON, _ := cmd.Flags().GetBool("json")
+	query := normalizeSearchText(strings.Join(args, " "))
+	queryGrams := searchNgrams(query)
+	if len(queryGrams) == 0 {
+		return fmt.Errorf("Search query %q has nothing to search for", strings.Join(args, " "))
+	}
+	slog.DebugContext(cmdCtx, "Starting sharedbox search command",
+		"query", query,
+		"ngrams", queryGrams,
+		)
+
+	cursor, err := store.SearchSharedboxes(cmdCtx, tenant, queryGrams)
+	if err != nil {
+		return fmt.Errorf("Failed to search sharedboxes: %w", err)
+	}
+	defer cursor.Close(cmdCtx)
+	results := []sharedboxSearchResult{}
+	for cursor.Next(cmdCtx) {
+		item, err := cursor.Item()
+		if err != nil {
+			return fmt.Errorf("Failed to decode sharedbox: %w", err)
+		}
+		overlap := ngramOverlap(queryGrams, sharedboxSearchNgrams(item))
+		if overlap < SEARCH_MIN_OVERLAP && !strings.Contains(normalizeSearchText(item.Item.DrivePath), query) {
+			continue
+		}
+		results = append(results, sharedboxSearchResult{
+			Score: scoreSharedbox(query, queryGrams, item),
+			Node: item.Item.Node,
+			Name: item.Item.Name,
+			DrivePath: item.Item.DrivePath,
+			URL: item.Item.URL,
+			ParentNode: item.ParentNode,
+		})
+	}
+	if err := cursor.Err(); err != nil {
+		return fmt.Errorf("Failed to search sharedboxes: %w", err)
+	}
+	slices.SortFunc(results, func(a, b sharedboxSearchResult) int {
+		if c := cmp.Compare(b.Score, a.Score); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.DrivePath, b.DrivePath)
+	})
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(results)
+	}
+	if len(results) == 0 {
+		fmt.Println("No matching folders")
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SCORE\tPATH\tURL")
+	for _, r := range results {
+		fmt.Fprintf(w, "%.2f\t%s\t%s\n", r.Score, r.DrivePath, r.URL)
+	}
+	return w.Flush()
+}
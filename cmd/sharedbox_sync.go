@@ -2,7 +2,6 @@ package cmd
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"log/slog"
 
@@ -12,13 +11,18 @@ import (
 
 	"github.com/briandowns/spinner"
 	"github.com/spf13/cobra"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/time/rate"
 )
 
+// SHAREDBOX_SYNC_DEFAULT_QUEUE_SIZE is the --queue-size default, also used
+// by the scheduled syncs of the daemon.
+const SHAREDBOX_SYNC_DEFAULT_QUEUE_SIZE = 5000
+
 var sharedboxSyncCmd = &cobra.Command{
 	Use: "sync",
+	Annotations: withBackends(BACKEND_STORAGE_WRITE),
 	PreRunE: func(cmd *cobra.Command, args []string) error {
 		if err := initAdminApiClient(); err != nil {
 			return fmt.Errorf("Failed to initialize Admin API client: %v", err)
@@ -30,137 +34,222 @@ var sharedboxSyncCmd = &cobra.Command{
 }
 
 func init() {
-	sharedboxSyncCmd.Flags().String("node", "", "Node to start syncing sharedboxes from")
-	sharedboxSyncCmd.Flags().Bool("recursive", false, "Sync sharedboxes recursively")
+	addSharedboxSyncFlags(sharedboxSyncCmd)
 }
 
-func runSharedboxSyncCmd(cmd *cobra.Command, args []string) error {
-	cmdCtx := cmd.Context()
+// addSharedboxSyncFlags registers the crawl flags shared by `sharedbox sync`
+// and `sharedbox files sync`.
+func addSharedboxSyncFlags(cmd *cobra.Command) {
+	cmd.Flags().String("node", "", "Node to start syncing sharedboxes from")
+	cmd.Flags().Bool("recursive", false, "Sync sharedboxes recursively")
+	cmd.Flags().Int("queue-size", SHAREDBOX_SYNC_DEFAULT_QUEUE_SIZE, "Maximum number of pending nodes kept in memory")
+	cmd.Flags().String("spill", NODE_QUEUE_SPILL_DISK, "Where pending nodes go when the in-memory queue is full: none (fail those nodes), disk or redis")
+	cmd.Flags().String("spill-dir", "", "Directory for the disk spill file (defaults to the system temp directory)")
+	cmd.Flags().Bool("distributed", false, "Share the node queue and rate limit with other sync processes through Redis (no sync lock is taken)")
+	cmd.Flags().String("queue-name", "", "Name of the distributed queue to join (defaults to the root node)")
+	cmd.Flags().Duration("visibility-timeout", 30*time.Second, "Time after which a node leased by a dead process is handed to another one")
+	cmd.Flags().Float64("global-rate", 40, "Requests per second shared by all distributed sync processes")
+	addSyncLockFlags(cmd)
+}
+
+type sharedboxSyncOptions struct {
+	RootNode string
+	Recursive bool
+	QueueSize int
+	Spill string
+	SpillDir string
+	Distributed bool
+	QueueName string
+	VisibilityTimeout time.Duration
+	GlobalRate float64
+	Lock syncLockOptions
+	Quiet bool
+	// Files also lists the files of every folder into the files collection.
+	Files bool
+}
+
+func sharedboxSyncOptionsFromFlags(cmd *cobra.Command) (sharedboxSyncOptions, error) {
+	var opts sharedboxSyncOptions
 	rootNode, err := cmd.Flags().GetString("node")
 	if err != nil {
-		return fmt.Errorf("Failed to get 'node' flag: %v", err)
+		return opts, fmt.Errorf("Failed to get 'node' flag: %v", err)
+	}
+	opts.RootNode = rootNode
+	opts.Recursive, _ = cmd.Flags().GetBool("recursive")
+	opts.QueueSize, _ = cmd.Flags().GetInt("queue-size")
+	opts.Spill, _ = cmd.Flags().GetString("spill")
+	opts.SpillDir, _ = cmd.Flags().GetString("spill-dir")
+	opts.Distributed, _ = cmd.Flags().GetBool("distributed")
+	opts.QueueName, _ = cmd.Flags().GetString("queue-name")
+	opts.VisibilityTimeout, _ = cmd.Flags().GetDuration("visibility-timeout")
+	if opts.VisibilityTimeout < NODE_QUEUE_MIN_VISIBILITY {
+		return opts, fmt.Errorf("--visibility-timeout must be at least %s", NODE_QUEUE_MIN_VISIBILITY)
+	}
+	opts.GlobalRate, _ = cmd.Flags().GetFloat64("global-rate")
+	opts.Lock = syncLockOptionsFromFlags(cmd)
+	return opts, nil
+}
+
+func runSharedboxSyncCmd(cmd *cobra.Command, args []string) error {
+	opts, err := sharedboxSyncOptionsFromFlags(cmd)
+	if err != nil {
+		return err
 	}
-	recursive, _ := cmd.Flags().GetBool("recursive")
+	return runSharedboxSync(cmd.Context(), adminApiClient, opts)
+}
+
+func runSharedboxSync(
+	cmdCtx context.Context,
+	client *AdminApiClient,
+	opts sharedboxSyncOptions,
+) (err error) {
+	syncType := "sharedbox"
+	if opts.Files {
+		syncType = "files"
+	}
+	defer func() {
+		observeSyncRun(syncType, err)
+	}()
+	if err := checkSyncTenant(); err != nil {
+		return err
+	}
+	rootNode := opts.RootNode
+	recursive := opts.Recursive
+	queueSize := opts.QueueSize
+	if queueSize < 1 {
+		return fmt.Errorf("--queue-size must be positive")
+	}
+	queueName := opts.QueueName
+	if queueName == "" {
+		queueName = rootNode
+	}
+	if opts.Files {
+		queueName = "files:" + queueName
+	}
+	queueName = fmt.Sprintf("%s:%s", tenant, queueName)
 	slog.DebugContext(cmdCtx, "Starting sharedbox sync command",
+		"sync", syncType,
 		"node", rootNode,
 		"recursive", recursive,
+		"queueSize", queueSize,
+		"spill", opts.Spill,
+		"distributed", opts.Distributed,
 		)
 
+	redisRequired := opts.Distributed || opts.Spill == NODE_QUEUE_SPILL_REDIS || errorSinkUsesRedis()
+	if err := initSyncRedis(cmdCtx, redisRequired); err != nil {
+		return err
+	}
+	// Distributed runs are expected to overlap; the shared queue keeps them
+	// from crawling the same tree twice.
+	if !opts.Distributed {
+		lock, lockCtx, err := acquireSyncLock(cmdCtx, opts.Lock, syncType, rootNode)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := lock.Release(); err != nil {
+				slog.ErrorContext(cmdCtx, "Failed to release sync lock",
+					"error", err,
+					)
+			}
+		}()
+		cmdCtx = lockCtx
+	}
+
 	const (
 		workerSize = 20
-		nodeChSize = 5000
 		itemChSize = 1000
 		reqPerSec = 40
 	)
-	nodeCh := make(chan string, nodeChSize)
-	itemCh := make(chan SharedBoxListItemWithParent, itemChSize)
-	errCh := make(chan NodeError, workerSize*2)
 	var (
-		wg sync.WaitGroup
-		jobWg sync.WaitGroup
-		mongoWg sync.WaitGroup
-		redisWg sync.WaitGroup
+		queue nodeQueue
+		limiter rateWaiter
 	)
-	limiter := rate.NewLimiter(rate.Limit(reqPerSec), 1)
-
-	mongoWorker := func(
-		cmdCtx context.Context,
-		mongoWg *sync.WaitGroup,
-		itemCh <-chan SharedBoxListItemWithParent,
-		errCh chan<- NodeError,
-	) {
-			defer mongoWg.Done()
-			const (
-				batchSize = 500
-				flushInterval = 3 * time.Second
-				reportInterval = 15 * time.Second
+	if opts.Distributed {
+		if !recursive {
+			return fmt.Errorf("--distributed requires --recursive")
+		}
+		redisLimiter, err := newRedisRateLimiter(fmt.Sprintf("directcloud:%s:admin", tenant), opts.GlobalRate)
+		if err != nil {
+			return err
+		}
+		limiter = redisLimiter
+		redisQueue := newRedisNodeQueue(queueName, opts.VisibilityTimeout)
+		defer redisQueue.Close()
+		seeded, err := redisQueue.Seed(cmdCtx, rootNode)
+		if err != nil {
+			return err
+		}
+		slog.InfoContext(cmdCtx, "Joined distributed sharedbox sync",
+			"queue", queueName,
+			"seeded", seeded,
 			)
-			collection := mongoClient.Database(mongoDatabase).Collection(MONGO_COLLECTION_SHAREDBOXES)
-			var (
-				writeModels []mongo.WriteModel
-				inserted int64
-				modified int64
-				matched int64
-				upserted int64
+		queue = redisQueue
+	} else {
+		spill, err := newNodeSpill(
+			opts.Spill,
+			opts.SpillDir,
+			fmt.Sprintf("%s:queue:%s:sync", sessionID, syncType),
 			)
-			flush := func() {
-				if len(writeModels) == 0 {
-					return
-				}
-				ctx, cancel := context.WithTimeout(cmdCtx, 15*time.Second)
-				defer cancel()
-				result, err := collection.BulkWrite(ctx, writeModels)
-				if err != nil {
-					errMsg := fmt.Sprintf("Bulk write error: %v", err)
-					slog.ErrorContext(ctx, errMsg)
-					select {
-					case errCh <- NodeError{
-						Err: errors.New(errMsg),
-					}:
-					default:
-						slog.WarnContext(cmdCtx, "errCh is full, dropping error message")
-					}
-				} else {
-					inserted += result.InsertedCount
-					modified += result.ModifiedCount
-					upserted += result.UpsertedCount
-					matched += result.MatchedCount
-				}
-				writeModels = nil
-			}
-			ticker := time.NewTicker(flushInterval)
-			reportTicker := time.NewTicker(reportInterval)
-			defer ticker.Stop()
-			defer reportTicker.Stop()
-			for {
-				select {
-				case item, ok := <-itemCh:
-					if !ok {
-						flush()
-						return
-					}
-					model := mongo.NewReplaceOneModel().SetFilter(bson.M{
-						"item.node": item.Item.Node,
-					}).SetReplacement(item).SetUpsert(true)
-					writeModels = append(writeModels, model)
-					if len(writeModels) >= batchSize {
-						flush()
-					}
-				case <-ticker.C:
-					flush()
-				case <-reportTicker.C:
-					slog.DebugContext(
-						cmdCtx, "Bulk write progress",
-						"insertedCount", inserted,
-						"modifiedCount", modified,
-						"upsertedCount", upserted,
-						"matchedCount", matched,
-						)
-				}
-			}
+		if err != nil {
+			return err
 		}
+		memoryQueue := newMemoryNodeQueue(queueSize, spill)
+		defer memoryQueue.Close()
+		if err := memoryQueue.Push(cmdCtx, rootNode); err != nil {
+			return err
+		}
+		limiter = rate.NewLimiter(rate.Limit(reqPerSec), 1)
+		queue = memoryQueue
+	}
+	sink, sinkTTL, err := newErrorSink(syncType)
+	if err != nil {
+		return err
+	}
+	defer sink.Close()
+	itemCh := make(chan SharedBoxListItemWithParent, itemChSize)
+	errCh := make(chan NodeError, workerSize*2)
+	var fileCh chan FileDocument
+	if opts.Files {
+		fileCh = make(chan FileDocument, itemChSize)
+	}
+	var (
+		mongoWg sync.WaitGroup
+		errorWg sync.WaitGroup
+	)
+	crawler := &sharedboxCrawler{
+		client: client,
+		tenant: tenant,
+		limiter: limiter,
+		queue: queue,
+		recursive: recursive,
+		workerSize: workerSize,
+		fileCh: fileCh,
+		pruneFiles: store.DeleteStaleFiles,
+	}
 
-	redisWorker := func(
-		redisWg *sync.WaitGroup,
+	errorWorker := func(
+		errorWg *sync.WaitGroup,
 		errCh <-chan NodeError,
 	) {
-			defer redisWg.Done()
+			defer errorWg.Done()
 			const (
 				batchSize = 100
 				flushInterval = 3 * time.Second
 			)
-			redisKey := fmt.Sprintf("%s:errors:sharedbox:sync", sessionID)
-			var buffer []string
+			var buffer []syncErrorRecord
 			flush := func() {
 				if len(buffer) == 0 {
 					return
 				}
 				ctx, cancel := context.WithTimeout(context.Background(), 3 * time.Second)
 				defer cancel()
-				err := redisClient.RPush(ctx, redisKey, buffer).Err()
+				err := sink.Write(ctx, buffer)
 				if err != nil {
 					slog.ErrorContext(
-						ctx, "Failed to push error batch to Redis",
+						ctx, "Failed to write sync error batch",
+						"count", len(buffer),
 						"err", err,
 						)
 				}
@@ -175,7 +264,7 @@ func runSharedboxSyncCmd(cmd *cobra.Command, args []string) error {
 						flush()
 						return
 					}
-					buffer = append(buffer, err.Err.Error())
+					buffer = append(buffer, newSyncErrorRecord(syncType, err, sinkTTL))
 					if len(buffer) >= batchSize {
 						flush()
 					}
@@ -185,71 +274,6 @@ func runSharedboxSyncCmd(cmd *cobra.Command, args []string) error {
 			}
 		}
 
-	worker := func(
-		ctx context.Context,
-		wg *sync.WaitGroup,
-		jobWg *sync.WaitGroup,
-		nodeCh chan string,
-		itemCh chan SharedBoxListItemWithParent,
-		errCh chan NodeError,
-	) {
-			defer wg.Done()
-			for {
-				select {
-				case <-ctx.Done():
-					slog.DebugContext(ctx, "Worker context done, exiting")
-					return
-				case node, ok := <-nodeCh:
-					if !ok {
-						slog.DebugContext(ctx, "Node channel closed, exiting worker")
-						return
-					}
-					func() {
-						defer jobWg.Done()
-						requestCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
-						defer cancel()
-						if err := limiter.Wait(requestCtx); err != nil {
-							errCh <- NodeError{
-								Node: node,
-								Err: err,
-							}
-							return
-						}
-						resp, err := adminApiClient.SharedboxesList(requestCtx, node)
-						if err != nil {
-							errCh <- NodeError{
-								Node: node,
-								Err: err,
-							}
-							return
-						}
-						if len(resp.Lists) > 0 {
-							slog.DebugContext(cmdCtx, "Fetched sharedbox list",
-								"node", node,
-								"count", len(resp.Lists),
-								)
-							for _, item := range resp.Lists {
-								itemCh <- SharedBoxListItemWithParent{
-									Item: item,
-									ParentNode: node,
-								}
-								if recursive {
-									jobWg.Add(1)
-									go func(ctx context.Context, node string) {
-										select {
-										case <-ctx.Done():
-											jobWg.Done()
-										case nodeCh <- node:
-										}
-									}(cmdCtx, item.Node)
-								}
-							}
-						}
-					}()
-				}
-			}
-		}
-
 	monitorWorker := func(
 		ctx context.Context,
 		ticker *time.Ticker,
@@ -260,9 +284,13 @@ func runSharedboxSyncCmd(cmd *cobra.Command, args []string) error {
 				case <-ctx.Done():
 					return
 				case <-ticker.C:
+					syncQueueDepth.WithLabelValues("nodes").Set(float64(queue.Len()))
+					syncQueueDepth.WithLabelValues("items").Set(float64(len(itemCh)))
+					syncQueueDepth.WithLabelValues("files").Set(float64(len(fileCh)))
+					syncQueueDepth.WithLabelValues("errors").Set(float64(len(errCh)))
 					slog.DebugContext(cmdCtx, "Internal status Monitor",
-						"nodeCh_len", len(nodeCh),
-						"nodeCh_cap", cap(nodeCh),
+						"queue_len", queue.Len(),
+						"queue_cap", queueSize,
 						"itemCh_len", len(itemCh),
 						"itemCh_cap", cap(itemCh),
 						"goroutines", runtime.NumGoroutine(),
@@ -274,41 +302,148 @@ func runSharedboxSyncCmd(cmd *cobra.Command, args []string) error {
 	s := spinner.New(spinner.CharSets[0], 200 * time.Millisecond)
 	s.FinalMSG = "sharedbox sync completed"
 	s.Suffix = " Syncing sharedboxes..."
-	s.Start()
+	if opts.Files {
+		s.FinalMSG = "sharedbox files sync completed"
+		s.Suffix = " Syncing sharedbox files..."
+	}
+	if !opts.Quiet {
+		s.Start()
+	}
 
 	mongoWg.Add(1)
-	go mongoWorker(cmdCtx, &mongoWg, itemCh, errCh)
-
-	redisWg.Add(1)
-	go redisWorker(&redisWg, errCh)
-
-	monitorTicker := time.NewTicker(5 * time.Second)
-	go monitorWorker(cmdCtx, monitorTicker)
-
-	for i := 0; i < workerSize; i++ {
-		wg.Add(1)
-		go worker(cmdCtx, &wg, &jobWg, nodeCh, itemCh, errCh)
+	go storageWriter(cmdCtx, &mongoWg, MONGO_COLLECTION_SHAREDBOXES, store.UpsertSharedboxes, itemCh, errCh)
+	if fileCh != nil {
+		mongoWg.Add(1)
+		go storageWriter(cmdCtx, &mongoWg, MONGO_COLLECTION_FILES, store.UpsertFiles, fileCh, errCh)
 	}
 
-	jobWg.Add(1)
-	nodeCh <- rootNode
+	errorWg.Add(1)
+	go errorWorker(&errorWg, errCh)
 
-	go func() {
-		jobWg.Wait()
-		close(nodeCh)
-	}()
+	monitorCtx, monitorCancel := context.WithCancel(cmdCtx)
+	defer monitorCancel()
+	monitorTicker := time.NewTicker(5 * time.Second)
+	go monitorWorker(monitorCtx, monitorTicker)
 
-	wg.Wait()
+	crawlErr := crawler.Run(cmdCtx, itemCh, errCh)
+	monitorCancel()
 
 	close(itemCh)
+	if fileCh != nil {
+		close(fileCh)
+	}
 	close(errCh)
 
 	mongoWg.Wait()
-	redisWg.Wait()
+	errorWg.Wait()
 
 	s.Stop()
 
+	if crawlErr != nil {
+		slog.ErrorContext(cmdCtx, "sharedbox sync command aborted",
+			"error", crawlErr,
+			)
+		return crawlErr
+	}
 	slog.InfoContext(cmdCtx, "sharedbox sync command finished")
 	return nil
 }
 
+// storageWriter upserts what arrives on itemCh into collection in batches,
+// flushing at least every few seconds.
+func storageWriter[T any](
+	cmdCtx context.Context,
+	wg *sync.WaitGroup,
+	collection string,
+	upsert func(ctx context.Context, items []T) (storageWriteResult, error),
+	itemCh <-chan T,
+	errCh chan<- NodeError,
+) {
+	defer wg.Done()
+	const (
+		batchSize = 500
+		flushInterval = 3 * time.Second
+		reportInterval = 15 * time.Second
+	)
+	var (
+		batch []T
+		inserted int64
+		modified int64
+		matched int64
+		upserted int64
+	)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(cmdCtx, 15*time.Second)
+		defer cancel()
+		ctx, span := tracer.Start(ctx, "storage.bulk_upsert",
+			trace.WithAttributes(
+				attribute.String("storage", storageBackend),
+				attribute.String("collection", collection),
+				attribute.Int("batch_size", len(batch)),
+				),
+			)
+		defer span.End()
+		result, err := upsert(ctx, batch)
+		if err != nil {
+			recordSpanError(span, err)
+			observeBulkWrite(collection, 0, 0, 0, 0, err)
+			slog.ErrorContext(ctx, "Bulk write error",
+				"collection", collection,
+				"error", err,
+				)
+			select {
+			case errCh <- NodeError{
+				Err: fmt.Errorf("Bulk write error: %w", err),
+				Class: ERROR_CLASS_STORAGE,
+			}:
+			default:
+				slog.WarnContext(cmdCtx, "errCh is full, dropping error message")
+			}
+		} else {
+			observeBulkWrite(
+				collection,
+				result.Inserted,
+				result.Modified,
+				result.Upserted,
+				result.Matched,
+				nil,
+				)
+			inserted += result.Inserted
+			modified += result.Modified
+			upserted += result.Upserted
+			matched += result.Matched
+		}
+		batch = nil
+	}
+	ticker := time.NewTicker(flushInterval)
+	reportTicker := time.NewTicker(reportInterval)
+	defer ticker.Stop()
+	defer reportTicker.Stop()
+	for {
+		select {
+		case item, ok := <-itemCh:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, item)
+			if len(batch) >= batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-reportTicker.C:
+			slog.DebugContext(
+				cmdCtx, "Bulk write progress",
+				"collection", collection,
+				"insertedCount", inserted,
+				"modifiedCount", modified,
+				"upsertedCount", upserted,
+				"matchedCount", matched,
+				)
+		}
+	}
+}
@@ -0,0 +1,332 @@
+package cmd
+
+import (
+	"cmp"
+	"encoding/csv"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/xuri/excelize/v2"
+)
+
+const (
+	USAGE_FORMAT_TABLE = "table"
+	USAGE_FORMAT_CSV = "csv"
+	USAGE_FORMAT_XLSX = "xlsx"
+)
+
+var sharedboxUsageCmd = &cobra.Command{
+	Use: "usage",
+	Annotations: withBackends(BACKEND_STORAGE),
+	RunE: runSharedboxUsageCmd,
+}
+
+func init() {
+	sharedboxUsageCmd.Flags().Int("top", 10, "Number of heaviest subtrees listed per root sharedbox, 0 for all")
+	sharedboxUsageCmd.Flags().String("format", USAGE_FORMAT_TABLE, "Output format: table, csv or xlsx")
+	sharedboxUsageCmd.Flags().String("dir", DIRECTORY_DEFAULT_EXPORT, "Directory to write the csv or xlsx file to (env EXPORT_DIR)")
+}
+
+// usageRow is a folder with the size of everything below it.
+type usageRow struct {
+	Root SharedBoxListItemWithParent
+	Folder SharedBoxListItemWithParent
+	Depth int
+	Bytes int64
+	Files int64
+	RootBytes int64
+}
+
+func (r usageRow) Share() float64 {
+	if r.RootBytes == 0 {
+		return 0
+	}
+	return float64(r.Bytes) / float64(r.RootBytes) * 100
+}
+
+func runSharedboxUsageCmd(cmd *cobra.Command, args []string) error {
+	cmdCtx := cmd.Context()
+	top, _ := cmd.Flags().GetInt("top")
+	if top < 0 {
+		return fmt.Errorf("--top must be 0 or more, got %d", top)
+	}
+	format, _ := cmd.Flags().GetString("format")
+	switch format {
+	case USAGE_FORMAT_TABLE, USAGE_FORMAT_CSV, USAGE_FORMAT_XLSX:
+	default:
+		return fmt.Errorf("Unknown format %q (expected %s, %s or %s)",
+			format, USAGE_FORMAT_TABLE, USAGE_FORMAT_CSV, USAGE_FORMAT_XLSX)
+	}
+
+	// Neither the folder listing nor the file listing carries folder totals,
+	// so usage is summed from the files collection filled by
+	// `sharedbox files sync`, and is only as complete as that mirror.
+	own, err := store.FolderUsage(cmdCtx, tenant)
+	if err != nil {
+		return fmt.Errorf("Failed to read folder usage: %w", err)
+	}
+	if len(own) == 0 {
+		return fmt.Errorf("No files in the mirror for tenant %s, run `sharedbox files sync --recursive` first", tenant)
+	}
+	folders := map[string]SharedBoxListItemWithParent{}
+	cursor, err := store.SharedboxCursor(cmdCtx, tenant)
+	if err != nil {
+		return fmt.Errorf("Failed to read sharedboxes: %w", err)
+	}
+	defer cursor.Close(cmdCtx)
+	for cursor.Next(cmdCtx) {
+		item, err := cursor.Item()
+		if err != nil {
+			return fmt.Errorf("Failed to decode sharedbox: %w", err)
+		}
+		folders[item.Item.Node] = item
+	}
+	if err := cursor.Err(); err != nil {
+		return fmt.Errorf("Failed to read sharedboxes: %w", err)
+	}
+	rows := rollupUsage(folders, own, top)
+	slog.DebugContext(cmdCtx, "Rolled up folder usage",
+		"folders", len(folders),
+		"foldersWithFiles", len(own),
+		"rows", len(rows),
+		)
+
+	if format == USAGE_FORMAT_TABLE {
+		return writeUsageTable(rows)
+	}
+	dir := flagOrEnv(cmd, "dir", "EXPORT_DIR")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("Failed to create export directory: %w", err)
+	}
+	now := time.Now().In(time.Local)
+	path := filepath.Join(dir, fmt.Sprintf("sharedbox_usage_%s_%s.%s", tenant, now.Format(time.DateTime), format))
+	if format == USAGE_FORMAT_CSV {
+		err = writeUsageCSV(path, rows)
+	} else {
+		err = writeUsageXLSX(path, rows)
+	}
+	if err != nil {
+		return err
+	}
+	slog.InfoContext(cmdCtx, "Exported sharedbox usage",
+		"file", path,
+		)
+	fmt.Printf("Usage report written to %s\n", path)
+	return nil
+}
+
+// rollupUsage adds the usage of every folder to all of its ancestors and
+// returns, per root sharedbox, the root followed by its top heaviest
+// subtrees, or all of them when top is 0. Roots are ordered by size. Folders
+// whose parent is not in the mirror, as after a sync started below the root,
+// count as roots. Files of a folder that is not in the mirror, such as the
+// --node of a files sync, are added to folders as a root named after its
+// node.
+func rollupUsage(
+	folders map[string]SharedBoxListItemWithParent,
+	own map[string]folderUsage,
+	top int,
+) []usageRow {
+	for node := range own {
+		if _, ok := folders[node]; !ok && node != "" {
+			folders[node] = SharedBoxListItemWithParent{
+				Item: SharedBoxListItem{
+					Node: node,
+					DrivePath: "node:" + node,
+				},
+			}
+		}
+	}
+	total := map[string]folderUsage{}
+	depth := map[string]int{}
+	root := map[string]string{}
+	for node := range folders {
+		chain := []string{node}
+		for parent := folders[node].ParentNode; len(chain) <= API_MAX_ANCESTOR_DEPTH; parent = folders[parent].ParentNode {
+			if _, ok := folders[parent]; !ok {
+				break
+			}
+			chain = append(chain, parent)
+		}
+		depth[node] = len(chain) - 1
+		root[node] = chain[len(chain)-1]
+		u := own[node]
+		for _, n := range chain {
+			t := total[n]
+			t.Bytes += u.Bytes
+			t.Files += u.Files
+			total[n] = t
+		}
+	}
+	subtrees := map[string][]string{}
+	var roots []string
+	for node := range folders {
+		if root[node] == node {
+			roots = append(roots, node)
+		} else {
+			subtrees[root[node]] = append(subtrees[root[node]], node)
+		}
+	}
+	heavier := func(a, b string) int {
+		if c := cmp.Compare(total[b].Bytes, total[a].Bytes); c != 0 {
+			return c
+		}
+		return cmp.Compare(folders[a].Item.DrivePath, folders[b].Item.DrivePath)
+	}
+	slices.SortFunc(roots, heavier)
+	var rows []usageRow
+	for _, r := range roots {
+		nodes := subtrees[r]
+		slices.SortFunc(nodes, heavier)
+		if top > 0 && len(nodes) > top {
+			nodes = nodes[:top]
+		}
+		for _, n := range append([]string{r}, nodes...) {
+			rows = append(rows, usageRow{
+				Root: folders[r],
+				Folder: folders[n],
+				Depth: depth[n],
+				Bytes: total[n].Bytes,
+				Files: total[n].Files,
+				RootBytes: total[r].Bytes,
+			})
+		}
+	}
+	return rows
+}
+
+var usageHeader = []string{
+	"RootPath",
+	"DrivePath",
+	"Node",
+	"Depth",
+	"Bytes",
+	"Files",
+	"ShareOfRoot",
+	"URL",
+}
+
+func writeUsageTable(rows []usageRow) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PATH\tSIZE\tFILES\tSHARE")
+	for i, r := range rows {
+		if r.Depth == 0 && i > 0 {
+			fmt.Fprintln(w, "\t\t\t")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%.1f%%\n", r.Folder.Item.DrivePath, formatBytes(r.Bytes), r.Files, r.Share())
+	}
+	return w.Flush()
+}
+
+func writeUsageCSV(path string, rows []usageRow) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("Failed to create usage file: %w", err)
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	if err := w.Write(usageHeader); err != nil {
+		return fmt.Errorf("Failed to write CSV header: %w", err)
+	}
+	for _, r := range rows {
+		record := []string{
+			r.Root.Item.DrivePath,
+			r.Folder.Item.DrivePath,
+			r.Folder.Item.Node,
+			strconv.Itoa(r.Depth),
+			strconv.FormatInt(r.Bytes, 10),
+			strconv.FormatInt(r.Files, 10),
+			strconv.FormatFloat(r.Share(), 'f', 2, 64),
+			r.Folder.Item.URL,
+		}
+		if err := w.Write(record); err != nil {
+			return fmt.Errorf("Failed to write CSV row: %w", err)
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return fmt.Errorf("Failed to flush CSV writer: %w", err)
+	}
+	return f.Close()
+}
+
+func writeUsageXLSX(path string, rows []usageRow) error {
+	const sheet = "Usage"
+	f := excelize.NewFile()
+	defer f.Close()
+	if err := f.SetSheetName("Sheet1", sheet); err != nil {
+		return fmt.Errorf("Failed to create XLSX sheet: %w", err)
+	}
+	header := make([]any, len(usageHeader))
+	for i, h := range usageHeader {
+		header[i] = h
+	}
+	if err := f.SetSheetRow(sheet, "A1", &header); err != nil {
+		return fmt.Errorf("Failed to write XLSX header: %w", err)
+	}
+	for i, r := range rows {
+		cell, _ := excelize.CoordinatesToCellName(1, i+2)
+		record := []any{
+			r.Root.Item.DrivePath,
+			r.Folder.Item.DrivePath,
+			r.Folder.Item.Node,
+			r.Depth,
+			r.Bytes,
+			r.Files,
+			r.Share() / 100,
+			r.Folder.Item.URL,
+		}
+		if err := f.SetSheetRow(sheet, cell, &record); err != nil {
+			return fmt.Errorf("Failed to write XLSX row: %w", err)
+		}
+	}
+	percent, err := f.NewStyle(&excelize.Style{NumFmt: 10})
+	if err != nil {
+		return fmt.Errorf("Failed to create XLSX style: %w", err)
+	}
+	last, _ := excelize.CoordinatesToCellName(7, len(rows)+1)
+	if err := f.SetCellStyle(sheet, "G2", last, percent); err != nil {
+		return fmt.Errorf("Failed to style XLSX cells: %w", err)
+	}
+	if err := f.SetPanes(sheet, &excelize.Panes{
+		Freeze: true,
+		YSplit: 1,
+		TopLeftCell: "A2",
+		ActivePane: "bottomLeft",
+	}); err != nil {
+		return fmt.Errorf("Failed to freeze XLSX header: %w", err)
+	}
+	if err := f.SetColWidth(sheet, "A", "B", 60); err != nil {
+		return fmt.Errorf("Failed to size XLSX columns: %w", err)
+	}
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("Failed to create usage file: %w", err)
+	}
+	defer out.Close()
+	if _, err := f.WriteTo(out); err != nil {
+		return fmt.Errorf("Failed to write usage file: %w", err)
+	}
+	return out.Close()
+}
+
+// formatBytes prints n with a binary unit, e.g. "1.5 GiB".
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
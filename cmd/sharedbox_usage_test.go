@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"testing"
+)
+
+func TestRollupUsage(t *testing.T) {
+	folder := func(node, parent string) SharedBoxListItemWithParent {
+		return SharedBoxListItemWithParent{
+			Item: SharedBoxListItem{Node: node, DrivePath: "/" + node},
+			ParentNode: parent,
+		}
+	}
+	type row struct {
+		Root string
+		Folder string
+		Depth int
+		Bytes int64
+		Files int64
+	}
+	for _, tc := range []struct {
+		name string
+		folders []SharedBoxListItemWithParent
+		own map[string]folderUsage
+		top int
+		want []row
+	}{
+		{
+			name: "sums up the tree",
+			folders: []SharedBoxListItemWithParent{
+				folder("a", ""),
+				folder("a1", "a"),
+				folder("a1x", "a1"),
+				folder("a2", "a"),
+			},
+			own: map[string]folderUsage{
+				"a": {Bytes: 1, Files: 1},
+				"a1": {Bytes: 10, Files: 1},
+				"a1x": {Bytes: 100, Files: 2},
+				"a2": {Bytes: 50, Files: 1},
+			},
+			want: []row{
+				{Root: "a", Folder: "a", Depth: 0, Bytes: 161, Files: 5},
+				{Root: "a", Folder: "a1", Depth: 1, Bytes: 110, Files: 3},
+				{Root: "a", Folder: "a1x", Depth: 2, Bytes: 100, Files: 2},
+				{Root: "a", Folder: "a2", Depth: 1, Bytes: 50, Files: 1},
+			},
+		},
+		{
+			name: "top limits subtrees per root",
+			folders: []SharedBoxListItemWithParent{
+				folder("a", ""),
+				folder("a1", "a"),
+				folder("a2", "a"),
+				folder("b", ""),
+				folder("b1", "b"),
+			},
+			own: map[string]folderUsage{
+				"a1": {Bytes: 10, Files: 1},
+				"a2": {Bytes: 20, Files: 1},
+				"b1": {Bytes: 5, Files: 1},
+			},
+			top: 1,
+			want: []row{
+				{Root: "a", Folder: "a", Depth: 0, Bytes: 30, Files: 2},
+				{Root: "a", Folder: "a2", Depth: 1, Bytes: 20, Files: 1},
+				{Root: "b", Folder: "b", Depth: 0, Bytes: 5, Files: 1},
+				{Root: "b", Folder: "b1", Depth: 1, Bytes: 5, Files: 1},
+			},
+		},
+		{
+			name: "folder not in the mirror",
+			folders: []SharedBoxListItemWithParent{
+				folder("x1", "x"),
+			},
+			own: map[string]folderUsage{
+				"x": {Bytes: 7, Files: 1},
+				"x1": {Bytes: 3, Files: 1},
+			},
+			want: []row{
+				{Root: "x", Folder: "x", Depth: 0, Bytes: 10, Files: 2},
+				{Root: "x", Folder: "x1", Depth: 1, Bytes: 3, Files: 1},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			folders := map[string]SharedBoxListItemWithParent{}
+			for _, f := range tc.folders {
+				folders[f.Item.Node] = f
+			}
+			rows := rollupUsage(folders, tc.own, tc.top)
+			if len(rows) != len(tc.want) {
+				t.Fatalf("got %d rows %v, expected %v", len(rows), rows, tc.want)
+			}
+			for i, r := range rows {
+				got := row{
+					Root: r.Root.Item.Node,
+					Folder: r.Folder.Item.Node,
+					Depth: r.Depth,
+					Bytes: r.Bytes,
+					Files: r.Files,
+				}
+				if got != tc.want[i] {
+					t.Errorf("row %d = %+v, expected %+v", i, got, tc.want[i])
+				}
+			}
+		})
+	}
+}
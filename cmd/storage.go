@@ -0,0 +1,158 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	STORAGE_MONGO = "mongo"
+	STORAGE_SQLITE = "sqlite"
+	SQLITE_DEFAULT_PATH = "data/abdsa.db"
+)
+
+// storage is where the mirrored sharedboxes and users are kept. Mongo is the
+// default; SQLite lets the tool run as a single binary with a local file.
+type storage interface {
+	// EnsureSchema prepares the schema. With writable it fails instead of
+	// warning when the schema is out of date.
+	EnsureSchema(ctx context.Context, writable bool) error
+	UpsertSharedboxes(ctx context.Context, items []SharedBoxListItemWithParent) (storageWriteResult, error)
+	UpsertUsers(ctx context.Context, users []UserDocument) (storageWriteResult, error)
+	UpsertFiles(ctx context.Context, files []FileDocument) (storageWriteResult, error)
+	// DeleteStaleFiles removes the files of parentNode whose file_seq is not
+	// in keep and returns how many were removed.
+	DeleteStaleFiles(ctx context.Context, tenant string, parentNode string, keep []string) (int64, error)
+	// FolderUsage sums the size and number of the files directly inside
+	// each folder of tenant, keyed by node.
+	FolderUsage(ctx context.Context, tenant string) (map[string]folderUsage, error)
+	// SharedboxCursor iterates the sharedboxes of tenant ordered by parent node.
+	SharedboxCursor(ctx context.Context, tenant string) (sharedboxCursor, error)
+	// SearchSharedboxes returns the sharedboxes of tenant that may match the
+	// search bigrams. Ranking and the final filtering are left to the caller.
+	SearchSharedboxes(ctx context.Context, tenant string, grams []string) (sharedboxCursor, error)
+	// FindSharedbox and FindSharedboxByPath return errSharedboxNotFound when
+	// the mirror has no such node. FindSharedboxByPath takes a path cleaned
+	// by cleanDrivePath and compares it with the cleaned stored paths.
+	FindSharedbox(ctx context.Context, tenant string, node string) (SharedBoxListItemWithParent, error)
+	FindSharedboxByPath(ctx context.Context, tenant string, drivePath string) (SharedBoxListItemWithParent, error)
+	// ListUsers and ListSharedboxes return one page of the matching documents
+	// of tenant together with the number of all matches.
+	ListUsers(ctx context.Context, tenant string, filter userFilter, limit int64, offset int64) ([]UserDocument, int64, error)
+	ListSharedboxes(ctx context.Context, tenant string, filter sharedboxFilter, limit int64, offset int64) ([]SharedBoxListItemWithParent, int64, error)
+	Ping(ctx context.Context) error
+	Close(ctx context.Context) error
+}
+
+// storageWriteResult mirrors the counts of a Mongo bulk write.
+type storageWriteResult struct {
+	Inserted int64
+	Modified int64
+	Upserted int64
+	Matched int64
+}
+
+type folderUsage struct {
+	Bytes int64
+	Files int64
+}
+
+// userFilter selects users for ListUsers. Query matches name, email or id
+// case-insensitively; empty fields do not filter.
+type userFilter struct {
+	Query string
+	RoleName string
+	Status *int
+}
+
+// sharedboxFilter selects sharedboxes for ListSharedboxes. Query matches the
+// name case-insensitively; a nil ParentNode does not filter, while a pointer
+// to "" selects the roots.
+type sharedboxFilter struct {
+	Query string
+	ParentNode *string
+	DrivePathPrefix string
+}
+
+type sharedboxCursor interface {
+	Next(ctx context.Context) bool
+	Item() (SharedBoxListItemWithParent, error)
+	Err() error
+	Close(ctx context.Context) error
+}
+
+var errSharedboxNotFound = errors.New("sharedbox not found")
+
+// cleanDrivePath puts a path in the form the API returns: NFC, a leading
+// slash and no trailing one.
+func cleanDrivePath(p string) string {
+	return path.Clean("/" + norm.NFC.String(strings.TrimSpace(p)))
+}
+
+// findSharedboxAncestors follows parent_node through s up to the root and
+// returns the ancestors of item, root first. The walk stops at the first
+// parent missing from the mirror and after API_MAX_ANCESTOR_DEPTH levels, so
+// a parent_node cycle cannot loop forever.
+func findSharedboxAncestors(
+	ctx context.Context,
+	s storage,
+	tenant string,
+	item SharedBoxListItemWithParent,
+) ([]SharedBoxListItemWithParent, error) {
+	ancestors := []SharedBoxListItemWithParent{}
+	parent := item.ParentNode
+	for depth := 0; parent != "" && depth < API_MAX_ANCESTOR_DEPTH; depth++ {
+		p, err := s.FindSharedbox(ctx, tenant, parent)
+		if errors.Is(err, errSharedboxNotFound) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Failed to look up node %s: %w", parent, err)
+		}
+		ancestors = append(ancestors, p)
+		parent = p.ParentNode
+	}
+	slices.Reverse(ancestors)
+	return ancestors, nil
+}
+
+var (
+	store storage
+	storageBackend string
+)
+
+// initStorage opens the backend selected by STORAGE_BACKEND.
+func initStorage(cmd *cobra.Command) error {
+	cmdCtx := cmd.Context()
+	storageBackend = os.Getenv("STORAGE_BACKEND")
+	switch storageBackend {
+	case STORAGE_MONGO, "":
+		storageBackend = STORAGE_MONGO
+		if err := initMongoClient(cmd); err != nil {
+			return err
+		}
+		store = newMongoStorage(mongoClient.Database(mongoDatabase))
+	case STORAGE_SQLITE:
+		path := os.Getenv("SQLITE_PATH")
+		if path == "" {
+			path = SQLITE_DEFAULT_PATH
+		}
+		s, err := newSqliteStorage(cmdCtx, path)
+		if err != nil {
+			return err
+		}
+		store = s
+	default:
+		return fmt.Errorf("Unknown storage backend %q (expected %s or %s)",
+			storageBackend, STORAGE_MONGO, STORAGE_SQLITE)
+	}
+	return store.EnsureSchema(cmdCtx, needsBackend(cmd, BACKEND_STORAGE_WRITE))
+}
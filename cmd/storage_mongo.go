@@ -0,0 +1,363 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type mongoStorage struct {
+	db *mongo.Database
+}
+
+func newMongoStorage(db *mongo.Database) *mongoStorage {
+	return &mongoStorage{
+		db: db,
+	}
+}
+
+// EnsureSchema applies all migrations to a new, empty database. On a
+// database that already has data, backfills may need the right tenant and
+// can take a while, so they are left to `db migrate up`. Until then writes
+// are refused: upserts keyed on fields a migration adds would collide with
+// the old unique indexes. Reads only get a warning.
+func (s *mongoStorage) EnsureSchema(cmdCtx context.Context, writable bool) error {
+	slog.DebugContext(cmdCtx, "Using MongoDB database",
+		"database", s.db.Name(),
+		)
+	ctx, cancel := context.WithTimeout(cmdCtx, 30*time.Second)
+	defer cancel()
+	pending, err := pendingMigrations(ctx, s.db)
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+	names, err := s.db.ListCollectionNames(ctx, bson.M{})
+	if err != nil {
+		return fmt.Errorf("Failed to list collections of %s: %w", s.db.Name(), err)
+	}
+	if len(names) == 0 {
+		slog.InfoContext(ctx, "Initializing empty MongoDB database",
+			"database", s.db.Name(),
+			)
+		_, err := migrateUp(ctx, s.db, 0)
+		// Another command initializing the same database at the same time
+		// records the migrations first; its indexes are the same as ours.
+		if mongo.IsDuplicateKeyError(err) {
+			return nil
+		}
+		return err
+	}
+	if writable {
+		return fmt.Errorf("MongoDB database %s has %d pending migrations, run `db migrate up` first",
+			s.db.Name(), len(pending))
+	}
+	slog.WarnContext(ctx, "MongoDB database has pending migrations, run `db migrate up`",
+		"database", s.db.Name(),
+		"pending", len(pending),
+		"latest", latestMigrationVersion(),
+		)
+	fmt.Fprintf(os.Stderr, "Warning: MongoDB database %s has %d pending migrations, run `db migrate up`\n",
+		s.db.Name(), len(pending))
+	return nil
+}
+
+func (s *mongoStorage) UpsertSharedboxes(
+	ctx context.Context,
+	items []SharedBoxListItemWithParent,
+) (storageWriteResult, error) {
+	writeModels := make([]mongo.WriteModel, 0, len(items))
+	for _, item := range items {
+		model := mongo.NewReplaceOneModel().SetFilter(bson.M{
+			"tenant": item.Tenant,
+			"item.node": item.Item.Node,
+		}).SetReplacement(mongoSharedboxDocument{
+			SharedBoxListItemWithParent: item,
+			SearchNgrams: sharedboxSearchNgrams(item),
+			DrivePathKey: cleanDrivePath(item.Item.DrivePath),
+		}).SetUpsert(true)
+		writeModels = append(writeModels, model)
+	}
+	return s.bulkWrite(ctx, MONGO_COLLECTION_SHAREDBOXES, writeModels)
+}
+
+func (s *mongoStorage) UpsertUsers(
+	ctx context.Context,
+	users []UserDocument,
+) (storageWriteResult, error) {
+	writeModels := make([]mongo.WriteModel, 0, len(users))
+	for _, user := range users {
+		model := mongo.NewReplaceOneModel().SetFilter(bson.M{
+			"tenant": user.Tenant,
+			"user_seq": user.UserSeq,
+		}).SetReplacement(user).SetUpsert(true)
+		writeModels = append(writeModels, model)
+	}
+	return s.bulkWrite(ctx, MONGO_COLLECTION_USERS, writeModels)
+}
+
+func (s *mongoStorage) UpsertFiles(
+	ctx context.Context,
+	files []FileDocument,
+) (storageWriteResult, error) {
+	writeModels := make([]mongo.WriteModel, 0, len(files))
+	for _, file := range files {
+		model := mongo.NewReplaceOneModel().SetFilter(bson.M{
+			"tenant": file.Tenant,
+			"file_seq": file.FileSeq,
+		}).SetReplacement(file).SetUpsert(true)
+		writeModels = append(writeModels, model)
+	}
+	return s.bulkWrite(ctx, MONGO_COLLECTION_FILES, writeModels)
+}
+
+func (s *mongoStorage) DeleteStaleFiles(
+	ctx context.Context,
+	tenant string,
+	parentNode string,
+	keep []string,
+) (int64, error) {
+	if keep == nil {
+		keep = []string{}
+	}
+	result, err := s.db.Collection(MONGO_COLLECTION_FILES).DeleteMany(ctx, bson.M{
+		"tenant": tenant,
+		"parent_node": parentNode,
+		"file_seq": bson.M{"$nin": keep},
+	})
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
+
+func (s *mongoStorage) FolderUsage(ctx context.Context, tenant string) (map[string]folderUsage, error) {
+	cursor, err := s.db.Collection(MONGO_COLLECTION_FILES).Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"tenant": tenant}}},
+		{{Key: "$group", Value: bson.M{
+			"_id": "$parent_node",
+			"bytes": bson.M{"$sum": "$size"},
+			"files": bson.M{"$sum": 1},
+		}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	usage := map[string]folderUsage{}
+	for cursor.Next(ctx) {
+		var row struct {
+			Node string `bson:"_id"`
+			Bytes int64 `bson:"bytes"`
+			Files int64 `bson:"files"`
+		}
+		if err := cursor.Decode(&row); err != nil {
+			return nil, err
+		}
+		usage[row.Node] = folderUsage{
+			Bytes: row.Bytes,
+			Files: row.Files,
+		}
+	}
+	return usage, cursor.Err()
+}
+
+func (s *mongoStorage) bulkWrite(
+	ctx context.Context,
+	collection string,
+	writeModels []mongo.WriteModel,
+) (storageWriteResult, error) {
+	var result storageWriteResult
+	if len(writeModels) == 0 {
+		return result, nil
+	}
+	r, err := s.db.Collection(collection).BulkWrite(ctx, writeModels)
+	if err != nil {
+		return result, err
+	}
+	result.Inserted = r.InsertedCount
+	result.Modified = r.ModifiedCount
+	result.Upserted = r.UpsertedCount
+	result.Matched = r.MatchedCount
+	return result, nil
+}
+
+func (s *mongoStorage) SharedboxCursor(ctx context.Context, tenant string) (sharedboxCursor, error) {
+	cursor, err := s.db.Collection(MONGO_COLLECTION_SHAREDBOXES).Find(
+		ctx,
+		bson.D{{Key: "tenant", Value: tenant}},
+		options.Find().SetSort(bson.D{
+			{Key: "parent_node", Value: 1},
+		}),
+		)
+	if err != nil {
+		return nil, err
+	}
+	return &mongoSharedboxCursor{cursor}, nil
+}
+
+func (s *mongoStorage) SearchSharedboxes(ctx context.Context, tenant string, grams []string) (sharedboxCursor, error) {
+	cursor, err := s.db.Collection(MONGO_COLLECTION_SHAREDBOXES).Find(
+		ctx,
+		bson.D{
+			{Key: "tenant", Value: tenant},
+			{Key: "search_ngrams", Value: bson.M{"$in": grams}},
+		},
+		options.Find().SetProjection(bson.M{"search_ngrams": 0}),
+		)
+	if err != nil {
+		return nil, err
+	}
+	return &mongoSharedboxCursor{cursor}, nil
+}
+
+func (s *mongoStorage) FindSharedbox(ctx context.Context, tenant string, node string) (SharedBoxListItemWithParent, error) {
+	return s.findSharedbox(ctx, bson.M{"tenant": tenant, "item.node": node})
+}
+
+func (s *mongoStorage) FindSharedboxByPath(ctx context.Context, tenant string, drivePath string) (SharedBoxListItemWithParent, error) {
+	return s.findSharedbox(ctx, bson.M{"tenant": tenant, "drive_path_key": drivePath})
+}
+
+func (s *mongoStorage) findSharedbox(ctx context.Context, filter bson.M) (SharedBoxListItemWithParent, error) {
+	var item SharedBoxListItemWithParent
+	err := s.db.Collection(MONGO_COLLECTION_SHAREDBOXES).
+		FindOne(ctx, filter, options.FindOne().SetProjection(bson.M{"search_ngrams": 0})).
+		Decode(&item)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return item, errSharedboxNotFound
+	}
+	return item, err
+}
+
+func (s *mongoStorage) ListUsers(
+	ctx context.Context,
+	tenant string,
+	filter userFilter,
+	limit int64,
+	offset int64,
+) ([]UserDocument, int64, error) {
+	query := bson.D{{Key: "tenant", Value: tenant}}
+	if filter.Query != "" {
+		query = append(query, bson.E{Key: "$or", Value: bson.A{
+			bson.M{"name": containsFilter(filter.Query)},
+			bson.M{"email": containsFilter(filter.Query)},
+			bson.M{"id": containsFilter(filter.Query)},
+		}})
+	}
+	if filter.RoleName != "" {
+		query = append(query, bson.E{Key: "role_name", Value: filter.RoleName})
+	}
+	if filter.Status != nil {
+		query = append(query, bson.E{Key: "status", Value: *filter.Status})
+	}
+	return findPage[UserDocument](
+		ctx,
+		s.db.Collection(MONGO_COLLECTION_USERS),
+		query,
+		bson.D{{Key: "user_seq", Value: 1}},
+		limit,
+		offset,
+		)
+}
+
+func (s *mongoStorage) ListSharedboxes(
+	ctx context.Context,
+	tenant string,
+	filter sharedboxFilter,
+	limit int64,
+	offset int64,
+) ([]SharedBoxListItemWithParent, int64, error) {
+	query := bson.D{{Key: "tenant", Value: tenant}}
+	if filter.Query != "" {
+		query = append(query, bson.E{Key: "item.name", Value: containsFilter(filter.Query)})
+	}
+	if filter.ParentNode != nil {
+		query = append(query, bson.E{Key: "parent_node", Value: *filter.ParentNode})
+	}
+	if filter.DrivePathPrefix != "" {
+		query = append(query, bson.E{Key: "item.drive_path", Value: bson.M{
+			"$regex": "^" + regexp.QuoteMeta(filter.DrivePathPrefix),
+		}})
+	}
+	return findPage[SharedBoxListItemWithParent](
+		ctx,
+		s.db.Collection(MONGO_COLLECTION_SHAREDBOXES),
+		query,
+		bson.D{{Key: "item.drive_path", Value: 1}},
+		limit,
+		offset,
+		)
+}
+
+// containsFilter matches field case-insensitively against a literal substring.
+func containsFilter(value string) bson.M {
+	return bson.M{
+		"$regex": regexp.QuoteMeta(value),
+		"$options": "i",
+	}
+}
+
+func findPage[T any](
+	ctx context.Context,
+	collection *mongo.Collection,
+	filter bson.D,
+	sort bson.D,
+	limit int64,
+	offset int64,
+) ([]T, int64, error) {
+	total, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+	cursor, err := collection.Find(ctx, filter, options.Find().
+		SetSort(sort).
+		SetSkip(offset).
+		SetLimit(limit).
+		SetProjection(bson.M{"search_ngrams": 0}))
+	if err != nil {
+		return nil, 0, err
+	}
+	items := []T{}
+	if err := cursor.All(ctx, &items); err != nil {
+		return nil, 0, err
+	}
+	return items, total, nil
+}
+
+func (s *mongoStorage) Ping(ctx context.Context) error {
+	return s.db.Client().Ping(ctx, nil)
+}
+
+// Close is a no-op; the client is shared and closed by the root command.
+func (s *mongoStorage) Close(ctx context.Context) error {
+	return nil
+}
+
+// mongoSharedboxDocument adds the search index and the cleaned drive path
+// looked up by FindSharedboxByPath, both kept only in Mongo.
+type mongoSharedboxDocument struct {
+	SharedBoxListItemWithParent `bson:",inline"`
+	SearchNgrams []string `bson:"search_ngrams"`
+	DrivePathKey string `bson:"drive_path_key"`
+}
+
+type mongoSharedboxCursor struct {
+	*mongo.Cursor
+}
+
+func (c *mongoSharedboxCursor) Item() (SharedBoxListItemWithParent, error) {
+	var item SharedBoxListItemWithParent
+	err := c.Decode(&item)
+	return item, err
+}
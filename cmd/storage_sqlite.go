@@ -0,0 +1,459 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+var sqliteSchema = []string{
+	`CREATE TABLE IF NOT EXISTS sharedboxes (
+		tenant TEXT NOT NULL,
+		node TEXT NOT NULL,
+		parent_node TEXT NOT NULL,
+		name TEXT NOT NULL,
+		url TEXT NOT NULL,
+		drive_path TEXT NOT NULL,
+		PRIMARY KEY (tenant, node)
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_sharedboxes_parent ON sharedboxes (tenant, parent_node)`,
+	`CREATE INDEX IF NOT EXISTS idx_sharedboxes_drive_path ON sharedboxes (tenant, drive_path)`,
+	`CREATE TABLE IF NOT EXISTS users (
+		tenant TEXT NOT NULL,
+		user_seq INTEGER NOT NULL,
+		role_name TEXT NOT NULL,
+		id TEXT NOT NULL,
+		name TEXT NOT NULL,
+		email TEXT NOT NULL,
+		lang TEXT NOT NULL,
+		phone TEXT NOT NULL,
+		status INTEGER NOT NULL,
+		regdate TEXT NOT NULL,
+		PRIMARY KEY (tenant, user_seq)
+	)`,
+	`CREATE TABLE IF NOT EXISTS files (
+		tenant TEXT NOT NULL,
+		file_seq TEXT NOT NULL,
+		parent_node TEXT NOT NULL,
+		name TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		extension TEXT NOT NULL,
+		datetime TEXT NOT NULL,
+		modified_at TEXT NOT NULL,
+		PRIMARY KEY (tenant, file_seq)
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_files_parent ON files (tenant, parent_node)`,
+}
+
+// sqliteStorage keeps the mirror in a local SQLite file using the pure Go
+// driver, so no cgo or database server is needed.
+type sqliteStorage struct {
+	db *sql.DB
+}
+
+func newSqliteStorage(ctx context.Context, path string) (*sqliteStorage, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("Failed to create SQLite directory: %w", err)
+	}
+	dsn := fmt.Sprintf("file:%s?_pragma=journal_mode(WAL)&_pragma=busy_timeout(10000)", path)
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open SQLite database: %w", err)
+	}
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("Failed to open SQLite database %s: %w", path, err)
+	}
+	return &sqliteStorage{
+		db: db,
+	}, nil
+}
+
+func (s *sqliteStorage) EnsureSchema(ctx context.Context, writable bool) error {
+	for _, stmt := range sqliteSchema {
+		if _, err := s.db.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("Failed to create SQLite schema: %w", err)
+		}
+	}
+	return nil
+}
+
+// upsert runs update for every row and falls back to insert when nothing
+// matched, so the counts match what a Mongo bulk write reports.
+func (s *sqliteStorage) upsert(
+	ctx context.Context,
+	n int,
+	update string,
+	insert string,
+	args func(i int) (updateArgs []any, insertArgs []any),
+) (storageWriteResult, error) {
+	var result storageWriteResult
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return result, err
+	}
+	defer tx.Rollback()
+	updateStmt, err := tx.PrepareContext(ctx, update)
+	if err != nil {
+		return result, err
+	}
+	defer updateStmt.Close()
+	insertStmt, err := tx.PrepareContext(ctx, insert)
+	if err != nil {
+		return result, err
+	}
+	defer insertStmt.Close()
+	for i := 0; i < n; i++ {
+		updateArgs, insertArgs := args(i)
+		r, err := updateStmt.ExecContext(ctx, updateArgs...)
+		if err != nil {
+			return result, err
+		}
+		if changed, _ := r.RowsAffected(); changed > 0 {
+			result.Matched++
+			result.Modified++
+			continue
+		}
+		r, err = insertStmt.ExecContext(ctx, insertArgs...)
+		if err != nil {
+			return result, err
+		}
+		if inserted, _ := r.RowsAffected(); inserted > 0 {
+			result.Upserted++
+		} else {
+			// The row exists and is unchanged.
+			result.Matched++
+		}
+	}
+	return result, tx.Commit()
+}
+
+func (s *sqliteStorage) UpsertSharedboxes(
+	ctx context.Context,
+	items []SharedBoxListItemWithParent,
+) (storageWriteResult, error) {
+	return s.upsert(ctx, len(items),
+		`UPDATE sharedboxes SET parent_node = ?, name = ?, url = ?, drive_path = ?
+		WHERE tenant = ? AND node = ?
+		AND (parent_node, name, url, drive_path) IS NOT (?, ?, ?, ?)`,
+		`INSERT OR IGNORE INTO sharedboxes (tenant, node, parent_node, name, url, drive_path)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		func(i int) ([]any, []any) {
+			it := items[i]
+			return []any{
+					it.ParentNode, it.Item.Name, it.Item.URL, it.Item.DrivePath,
+					it.Tenant, it.Item.Node,
+					it.ParentNode, it.Item.Name, it.Item.URL, it.Item.DrivePath,
+				}, []any{
+					it.Tenant, it.Item.Node, it.ParentNode, it.Item.Name, it.Item.URL, it.Item.DrivePath,
+				}
+		})
+}
+
+func (s *sqliteStorage) UpsertUsers(
+	ctx context.Context,
+	users []UserDocument,
+) (storageWriteResult, error) {
+	return s.upsert(ctx, len(users),
+		`UPDATE users SET role_name = ?, id = ?, name = ?, email = ?, lang = ?, phone = ?, status = ?, regdate = ?
+		WHERE tenant = ? AND user_seq = ?
+		AND (role_name, id, name, email, lang, phone, status, regdate) IS NOT (?, ?, ?, ?, ?, ?, ?, ?)`,
+		`INSERT OR IGNORE INTO users (tenant, user_seq, role_name, id, name, email, lang, phone, status, regdate)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		func(i int) ([]any, []any) {
+			u := users[i]
+			fields := []any{u.RoleName, u.ID, u.Name, u.Email, u.Lang, u.Phone, u.Status, u.RegDate}
+			updateArgs := append(append(append([]any{}, fields...), u.Tenant, u.UserSeq), fields...)
+			insertArgs := append([]any{u.Tenant, u.UserSeq}, fields...)
+			return updateArgs, insertArgs
+		})
+}
+
+func (s *sqliteStorage) UpsertFiles(
+	ctx context.Context,
+	files []FileDocument,
+) (storageWriteResult, error) {
+	return s.upsert(ctx, len(files),
+		`UPDATE files SET parent_node = ?, name = ?, size = ?, extension = ?, datetime = ?, modified_at = ?
+		WHERE tenant = ? AND file_seq = ?
+		AND (parent_node, name, size, extension, datetime, modified_at) IS NOT (?, ?, ?, ?, ?, ?)`,
+		`INSERT OR IGNORE INTO files (tenant, file_seq, parent_node, name, size, extension, datetime, modified_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		func(i int) ([]any, []any) {
+			f := files[i]
+			fields := []any{f.ParentNode, f.Name, int64(f.Size), f.Extension, f.Datetime, f.ModifiedAt.UTC().Format(time.RFC3339)}
+			updateArgs := append(append(append([]any{}, fields...), f.Tenant, string(f.FileSeq)), fields...)
+			insertArgs := append([]any{f.Tenant, string(f.FileSeq)}, fields...)
+			return updateArgs, insertArgs
+		})
+}
+
+func (s *sqliteStorage) DeleteStaleFiles(
+	ctx context.Context,
+	tenant string,
+	parentNode string,
+	keep []string,
+) (int64, error) {
+	if keep == nil {
+		keep = []string{}
+	}
+	keepJSON, err := json.Marshal(keep)
+	if err != nil {
+		return 0, err
+	}
+	r, err := s.db.ExecContext(ctx,
+		`DELETE FROM files WHERE tenant = ? AND parent_node = ?
+		AND file_seq NOT IN (SELECT value FROM json_each(?))`,
+		tenant, parentNode, string(keepJSON),
+		)
+	if err != nil {
+		return 0, err
+	}
+	return r.RowsAffected()
+}
+
+func (s *sqliteStorage) FolderUsage(ctx context.Context, tenant string) (map[string]folderUsage, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT parent_node, SUM(size), COUNT(*) FROM files WHERE tenant = ? GROUP BY parent_node`,
+		tenant,
+		)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	usage := map[string]folderUsage{}
+	for rows.Next() {
+		var node string
+		var u folderUsage
+		if err := rows.Scan(&node, &u.Bytes, &u.Files); err != nil {
+			return nil, err
+		}
+		usage[node] = u
+	}
+	return usage, rows.Err()
+}
+
+func (s *sqliteStorage) SharedboxCursor(ctx context.Context, tenant string) (sharedboxCursor, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT tenant, node, parent_node, name, url, drive_path
+		FROM sharedboxes WHERE tenant = ? ORDER BY parent_node`,
+		tenant,
+		)
+	if err != nil {
+		return nil, err
+	}
+	return &sqliteSharedboxCursor{rows}, nil
+}
+
+// SearchSharedboxes scans all sharedboxes of tenant; the local mirror is
+// small enough that ranking every row is cheaper than keeping an index.
+func (s *sqliteStorage) SearchSharedboxes(ctx context.Context, tenant string, grams []string) (sharedboxCursor, error) {
+	return s.SharedboxCursor(ctx, tenant)
+}
+
+func (s *sqliteStorage) FindSharedbox(ctx context.Context, tenant string, node string) (SharedBoxListItemWithParent, error) {
+	return s.findSharedbox(ctx, "node", tenant, node)
+}
+
+// FindSharedboxByPath tries the stored path as is first, and otherwise scans
+// the sharedboxes of tenant for a path that only matches once cleaned, as
+// SearchSharedboxes does.
+func (s *sqliteStorage) FindSharedboxByPath(ctx context.Context, tenant string, drivePath string) (SharedBoxListItemWithParent, error) {
+	item, err := s.findSharedbox(ctx, "drive_path", tenant, drivePath)
+	if !errors.Is(err, errSharedboxNotFound) {
+		return item, err
+	}
+	cursor, err := s.SharedboxCursor(ctx, tenant)
+	if err != nil {
+		return item, err
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		item, err := cursor.Item()
+		if err != nil {
+			return item, err
+		}
+		if cleanDrivePath(item.Item.DrivePath) == drivePath {
+			return item, nil
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return item, err
+	}
+	return item, errSharedboxNotFound
+}
+
+func (s *sqliteStorage) findSharedbox(ctx context.Context, column string, tenant string, value string) (SharedBoxListItemWithParent, error) {
+	var item SharedBoxListItemWithParent
+	err := s.db.QueryRowContext(ctx,
+		`SELECT tenant, node, parent_node, name, url, drive_path
+		FROM sharedboxes WHERE tenant = ? AND `+column+` = ? LIMIT 1`,
+		tenant, value,
+		).Scan(
+		&item.Tenant,
+		&item.Item.Node,
+		&item.ParentNode,
+		&item.Item.Name,
+		&item.Item.URL,
+		&item.Item.DrivePath,
+		)
+	if errors.Is(err, sql.ErrNoRows) {
+		return item, errSharedboxNotFound
+	}
+	return item, err
+}
+
+func (s *sqliteStorage) ListUsers(
+	ctx context.Context,
+	tenant string,
+	filter userFilter,
+	limit int64,
+	offset int64,
+) ([]UserDocument, int64, error) {
+	where := "tenant = ?"
+	args := []any{tenant}
+	if filter.Query != "" {
+		pattern := sqliteContainsPattern(filter.Query)
+		where += ` AND (name LIKE ? ESCAPE '\' OR email LIKE ? ESCAPE '\' OR id LIKE ? ESCAPE '\')`
+		args = append(args, pattern, pattern, pattern)
+	}
+	if filter.RoleName != "" {
+		where += " AND role_name = ?"
+		args = append(args, filter.RoleName)
+	}
+	if filter.Status != nil {
+		where += " AND status = ?"
+		args = append(args, *filter.Status)
+	}
+	var total int64
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM users WHERE `+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT tenant, user_seq, role_name, id, name, email, lang, phone, status, regdate
+		FROM users WHERE `+where+` ORDER BY user_seq LIMIT ? OFFSET ?`,
+		append(args, limit, offset)...,
+		)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	users := []UserDocument{}
+	for rows.Next() {
+		var u UserDocument
+		if err := rows.Scan(
+			&u.Tenant,
+			&u.UserSeq,
+			&u.RoleName,
+			&u.ID,
+			&u.Name,
+			&u.Email,
+			&u.Lang,
+			&u.Phone,
+			&u.Status,
+			&u.RegDate,
+			); err != nil {
+			return nil, 0, err
+		}
+		users = append(users, u)
+	}
+	return users, total, rows.Err()
+}
+
+func (s *sqliteStorage) ListSharedboxes(
+	ctx context.Context,
+	tenant string,
+	filter sharedboxFilter,
+	limit int64,
+	offset int64,
+) ([]SharedBoxListItemWithParent, int64, error) {
+	where := "tenant = ?"
+	args := []any{tenant}
+	if filter.Query != "" {
+		where += ` AND name LIKE ? ESCAPE '\'`
+		args = append(args, sqliteContainsPattern(filter.Query))
+	}
+	if filter.ParentNode != nil {
+		where += " AND parent_node = ?"
+		args = append(args, *filter.ParentNode)
+	}
+	if filter.DrivePathPrefix != "" {
+		// LIKE ignores case, the prefix match must not.
+		where += " AND substr(drive_path, 1, length(?)) = ?"
+		args = append(args, filter.DrivePathPrefix, filter.DrivePathPrefix)
+	}
+	var total int64
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM sharedboxes WHERE `+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT tenant, node, parent_node, name, url, drive_path
+		FROM sharedboxes WHERE `+where+` ORDER BY drive_path LIMIT ? OFFSET ?`,
+		append(args, limit, offset)...,
+		)
+	if err != nil {
+		return nil, 0, err
+	}
+	cursor := &sqliteSharedboxCursor{rows}
+	defer cursor.Close(ctx)
+	items := []SharedBoxListItemWithParent{}
+	for cursor.Next(ctx) {
+		item, err := cursor.Item()
+		if err != nil {
+			return nil, 0, err
+		}
+		items = append(items, item)
+	}
+	return items, total, cursor.Err()
+}
+
+// sqliteContainsPattern builds a LIKE pattern matching value literally
+// anywhere. Like the Mongo regex, it ignores case (for ASCII only).
+func sqliteContainsPattern(value string) string {
+	return "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(value) + "%"
+}
+
+func (s *sqliteStorage) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+func (s *sqliteStorage) Close(ctx context.Context) error {
+	return s.db.Close()
+}
+
+type sqliteSharedboxCursor struct {
+	rows *sql.Rows
+}
+
+func (c *sqliteSharedboxCursor) Next(ctx context.Context) bool {
+	return c.rows.Next()
+}
+
+func (c *sqliteSharedboxCursor) Item() (SharedBoxListItemWithParent, error) {
+	var item SharedBoxListItemWithParent
+	err := c.rows.Scan(
+		&item.Tenant,
+		&item.Item.Node,
+		&item.ParentNode,
+		&item.Item.Name,
+		&item.Item.URL,
+		&item.Item.DrivePath,
+		)
+	return item, err
+}
+
+func (c *sqliteSharedboxCursor) Err() error {
+	return c.rows.Err()
+}
+
+func (c *sqliteSharedboxCursor) Close(ctx context.Context) error {
+	return c.rows.Close()
+}
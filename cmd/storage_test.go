@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+func TestCleanDrivePath(t *testing.T) {
+	for _, tc := range []struct {
+		in string
+		want string
+	}{
+		{in: "/Shared/営業部", want: "/Shared/営業部"},
+		{in: "Shared/営業部/", want: "/Shared/営業部"},
+		{in: "  /Shared//営業部/./ ", want: "/Shared/営業部"},
+		{in: "/Shared/a/../b", want: "/Shared/b"},
+		{in: "", want: "/"},
+		// macOS pastes decomposed kana: ガ as カ + combining mark.
+		{in: norm.NFD.String("/Shared/ガイド"), want: "/Shared/ガイド"},
+	} {
+		if got := cleanDrivePath(tc.in); got != tc.want {
+			t.Errorf("cleanDrivePath(%q) = %q, expected %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+// testSqliteStorage returns an empty SQLite storage in a temporary directory.
+func testSqliteStorage(t *testing.T) *sqliteStorage {
+	t.Helper()
+	ctx := context.Background()
+	s, err := newSqliteStorage(ctx, filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		s.Close(ctx)
+	})
+	if err := s.EnsureSchema(ctx, true); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestFindSharedboxAncestors(t *testing.T) {
+	ctx := context.Background()
+	s := testSqliteStorage(t)
+	folder := func(node, parent, drivePath string) SharedBoxListItemWithParent {
+		return SharedBoxListItemWithParent{
+			Tenant: "t",
+			Item: SharedBoxListItem{Node: node, Name: node, DrivePath: drivePath},
+			ParentNode: parent,
+		}
+	}
+	_, err := s.UpsertSharedboxes(ctx, []SharedBoxListItemWithParent{
+		folder("a", "", "/A"),
+		folder("b", "a", norm.NFD.String("/A/ガイド/")),
+		folder("c", "b", "/A/ガイド/C"),
+		// A parent_node cycle must not loop forever.
+		folder("x", "y", "/X"),
+		folder("y", "x", "/Y"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	item, err := s.FindSharedboxByPath(ctx, "t", cleanDrivePath("/A/ガイド"))
+	if err != nil {
+		t.Fatalf("FindSharedboxByPath with a decomposed stored path: %v", err)
+	}
+	if item.Item.Node != "b" {
+		t.Fatalf("found node %s, expected b", item.Item.Node)
+	}
+	if _, err := s.FindSharedboxByPath(ctx, "t", "/A/missing"); !errors.Is(err, errSharedboxNotFound) {
+		t.Fatalf("FindSharedboxByPath of a missing path: %v, expected errSharedboxNotFound", err)
+	}
+
+	c, err := s.FindSharedbox(ctx, "t", "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ancestors, err := findSharedboxAncestors(ctx, s, "t", c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var nodes []string
+	for _, a := range ancestors {
+		nodes = append(nodes, a.Item.Node)
+	}
+	if len(nodes) != 2 || nodes[0] != "a" || nodes[1] != "b" {
+		t.Fatalf("ancestors %v, expected [a b]", nodes)
+	}
+
+	x, err := s.FindSharedbox(ctx, "t", "x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ancestors, err = findSharedboxAncestors(ctx, s, "t", x)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ancestors) != API_MAX_ANCESTOR_DEPTH {
+		t.Fatalf("%d ancestors in a cycle, expected the cap of %d", len(ancestors), API_MAX_ANCESTOR_DEPTH)
+	}
+}
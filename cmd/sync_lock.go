@@ -0,0 +1,271 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/spf13/cobra"
+)
+
+const (
+	SYNC_LOCK_TTL = 30 * time.Second
+	SYNC_LOCK_POLL_INTERVAL = time.Second
+)
+
+// errSyncLockLost is the cause of the lock context when the lock was taken
+// over or could not be renewed before it expired.
+var errSyncLockLost = errors.New("sync lock lost")
+
+// syncLockHolder is stored as the lock value so that a blocked run can tell
+// the operator who is holding it.
+type syncLockHolder struct {
+	SessionID string `json:"session_id"`
+	Host string `json:"host"`
+	PID int `json:"pid"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+type SyncLockedError struct {
+	Key string
+	Holder syncLockHolder
+	// Local is set for a lock file, which --force cannot take over.
+	Local bool
+}
+func (e *SyncLockedError) Error() string {
+	hint := "use --wait to wait for it or --force to take it over"
+	if e.Local {
+		hint = "use --wait to wait for it"
+	}
+	return fmt.Sprintf("%s is held by session %s on host %s (pid %d) since %s; %s",
+		e.Key,
+		e.Holder.SessionID,
+		e.Holder.Host,
+		e.Holder.PID,
+		e.Holder.StartedAt.In(time.Local).Format(time.DateTime),
+		hint,
+		)
+}
+
+// syncLock is a Redis lock that is renewed in the background until Release
+// is called. If another process took the lock over, or renewal kept failing
+// until the lock may have expired, the context returned by acquireSyncLock is
+// cancelled with errSyncLockLost. Without Redis it is
+// a lock on a local file instead, which only keeps out syncs on the same host
+// and is released by the OS when the process dies.
+type syncLock struct {
+	key string
+	value string
+	file *os.File
+	cancel context.CancelCauseFunc
+	stop chan struct{}
+	done chan struct{}
+}
+
+var syncLockRenewScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+var syncLockReleaseScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+type syncLockOptions struct {
+	Wait time.Duration
+	Force bool
+}
+
+func addSyncLockFlags(cmd *cobra.Command) {
+	cmd.Flags().Duration("wait", 0, "Wait up to this long for a running sync to finish instead of failing")
+	cmd.Flags().Bool("force", false, "Take over the sync lock even if another run holds it")
+}
+
+func syncLockOptionsFromFlags(cmd *cobra.Command) syncLockOptions {
+	var opts syncLockOptions
+	opts.Wait, _ = cmd.Flags().GetDuration("wait")
+	opts.Force, _ = cmd.Flags().GetBool("force")
+	return opts
+}
+
+// initSyncRedis connects to Redis when the sync requires it, for
+// --distributed, --spill redis or the redis error sink. Otherwise it only
+// connects when Redis is configured, so that the sync lock is shared between
+// hosts.
+func initSyncRedis(ctx context.Context, required bool) error {
+	if !redisConfigured() {
+		if !required {
+			return nil
+		}
+		return fmt.Errorf("Redis is not configured, but --distributed, --spill %s or ERROR_SINK=%s (the default with the mongo backend) needs it; set ERROR_SINK=%s to sync without Redis",
+			NODE_QUEUE_SPILL_REDIS, ERROR_SINK_REDIS, ERROR_SINK_NDJSON)
+	}
+	return connectRedis(ctx)
+}
+
+func syncLockKey(syncType string, rootNode string) string {
+	if rootNode == "" {
+		return redisKey(fmt.Sprintf("lock:%s:%s:sync", tenant, syncType))
+	}
+	return redisKey(fmt.Sprintf("lock:%s:%s:sync:%s", tenant, syncType, rootNode))
+}
+
+func acquireSyncLock(
+	cmdCtx context.Context,
+	opts syncLockOptions,
+	syncType string,
+	rootNode string,
+) (*syncLock, context.Context, error) {
+	wait := opts.Wait
+	force := opts.Force
+	host, _ := os.Hostname()
+	value, err := json.Marshal(syncLockHolder{
+		SessionID: sessionID,
+		Host: host,
+		PID: os.Getpid(),
+		StartedAt: time.Now(),
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to encode sync lock holder: %w", err)
+	}
+	if redisClient == nil {
+		return acquireFileSyncLock(cmdCtx, opts, syncType, rootNode, value)
+	}
+	key := syncLockKey(syncType, rootNode)
+	deadline := time.Now().Add(wait)
+	for {
+		var ok bool
+		if force {
+			err = redisClient.Set(cmdCtx, key, value, SYNC_LOCK_TTL).Err()
+			ok = err == nil
+		} else {
+			ok, err = redisClient.SetNX(cmdCtx, key, value, SYNC_LOCK_TTL).Result()
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("Failed to acquire sync lock: %w", err)
+		}
+		if ok {
+			break
+		}
+		lockedErr := &SyncLockedError{
+			Key: key,
+		}
+		current, err := redisClient.Get(cmdCtx, key).Bytes()
+		if err == nil {
+			_ = json.Unmarshal(current, &lockedErr.Holder)
+		}
+		// A lock that expired between SETNX and GET is retried after the
+		// same pause, so that many waiters do not spin on it.
+		if time.Now().After(deadline) && !errors.Is(err, redis.Nil) {
+			return nil, nil, lockedErr
+		}
+		if err == nil {
+			slog.InfoContext(cmdCtx, "Waiting for sync lock",
+				"key", key,
+				"holderSessionID", lockedErr.Holder.SessionID,
+				"holderHost", lockedErr.Holder.Host,
+				)
+		}
+		select {
+		case <-cmdCtx.Done():
+			return nil, nil, cmdCtx.Err()
+		case <-time.After(SYNC_LOCK_POLL_INTERVAL):
+		}
+	}
+	if force {
+		slog.WarnContext(cmdCtx, "Sync lock taken over by force",
+			"key", key,
+			)
+	}
+	slog.DebugContext(cmdCtx, "Sync lock acquired",
+		"key", key,
+		)
+	lockCtx, cancel := context.WithCancelCause(cmdCtx)
+	l := &syncLock{
+		key: key,
+		value: string(value),
+		cancel: cancel,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go l.renewLoop(lockCtx)
+	return l, lockCtx, nil
+}
+
+func (l *syncLock) renewLoop(ctx context.Context) {
+	defer close(l.done)
+	ticker := time.NewTicker(SYNC_LOCK_TTL / 3)
+	defer ticker.Stop()
+	renewedAt := time.Now()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+		}
+		renewCtx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		renewed, err := syncLockRenewScript.Run(
+			renewCtx,
+			redisClient,
+			[]string{l.key},
+			l.value,
+			SYNC_LOCK_TTL.Milliseconds(),
+			).Int()
+		cancel()
+		if err != nil {
+			// The lock expires SYNC_LOCK_TTL after the last renewal, after
+			// which another run may hold it.
+			if time.Since(renewedAt) >= SYNC_LOCK_TTL {
+				slog.ErrorContext(ctx, "Sync lock could not be renewed before it expired, aborting",
+					"key", l.key,
+					"error", err,
+					)
+				l.cancel(fmt.Errorf("%w: %s could not be renewed: %w", errSyncLockLost, l.key, err))
+				return
+			}
+			slog.WarnContext(ctx, "Failed to renew sync lock",
+				"key", l.key,
+				"error", err,
+				"expiresIn", SYNC_LOCK_TTL-time.Since(renewedAt),
+				)
+			continue
+		}
+		if renewed == 0 {
+			slog.ErrorContext(ctx, "Sync lock lost, aborting",
+				"key", l.key,
+				)
+			l.cancel(fmt.Errorf("%w: %s is held by another run", errSyncLockLost, l.key))
+			return
+		}
+		renewedAt = time.Now()
+	}
+}
+
+func (l *syncLock) Release() error {
+	if l.file != nil {
+		l.cancel(nil)
+		// The file is kept: removing it would let a process that opened it
+		// before the removal lock a file nobody else can see.
+		unlockFile(l.file)
+		return l.file.Close()
+	}
+	close(l.stop)
+	<-l.done
+	l.cancel(nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if err := syncLockReleaseScript.Run(ctx, redisClient, []string{l.key}, l.value).Err(); err != nil {
+		return fmt.Errorf("Failed to release sync lock: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const SYNC_LOCK_DIR = "locks"
+
+// syncLockPath returns <user cache dir>/amazing-brain-dead-storage-accessor/
+// locks/<tenant>-<type>-sync[-<node>].lock.
+func syncLockPath(syncType string, rootNode string) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("Failed to find the user cache directory for the sync lock: %w", err)
+	}
+	name := fmt.Sprintf("%s-%s-sync", tenant, syncType)
+	if rootNode != "" {
+		name += "-" + rootNode
+	}
+	name = strings.NewReplacer("/", "_", string(filepath.Separator), "_").Replace(name)
+	return filepath.Join(dir, CONFIG_APP_DIR, SYNC_LOCK_DIR, name+".lock"), nil
+}
+
+// acquireFileSyncLock takes the local lock file of the sync and writes the
+// holder into it for the runs it blocks.
+func acquireFileSyncLock(
+	cmdCtx context.Context,
+	opts syncLockOptions,
+	syncType string,
+	rootNode string,
+	value []byte,
+) (*syncLock, context.Context, error) {
+	path, err := syncLockPath(syncType, rootNode)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, nil, fmt.Errorf("Failed to create sync lock directory: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to open sync lock file: %w", err)
+	}
+	deadline := time.Now().Add(opts.Wait)
+	for {
+		ok, err := lockFile(f)
+		if err != nil {
+			f.Close()
+			return nil, nil, fmt.Errorf("Failed to acquire sync lock: %w", err)
+		}
+		if ok {
+			break
+		}
+		lockedErr := &SyncLockedError{
+			Key: path,
+			Local: true,
+		}
+		if current, err := io.ReadAll(io.NewSectionReader(f, 0, 1<<16)); err == nil {
+			_ = json.Unmarshal(current, &lockedErr.Holder)
+		}
+		if opts.Force {
+			f.Close()
+			return nil, nil, fmt.Errorf("%s is held by the running process %d on this host and cannot be taken over", path, lockedErr.Holder.PID)
+		}
+		if time.Now().After(deadline) {
+			f.Close()
+			return nil, nil, lockedErr
+		}
+		slog.InfoContext(cmdCtx, "Waiting for sync lock",
+			"key", path,
+			"holderSessionID", lockedErr.Holder.SessionID,
+			"holderPID", lockedErr.Holder.PID,
+			)
+		select {
+		case <-cmdCtx.Done():
+			f.Close()
+			return nil, nil, cmdCtx.Err()
+		case <-time.After(SYNC_LOCK_POLL_INTERVAL):
+		}
+	}
+	if err := f.Truncate(0); err == nil {
+		f.WriteAt(value, 0)
+	}
+	slog.DebugContext(cmdCtx, "Sync lock acquired",
+		"key", path,
+		)
+	lockCtx, cancel := context.WithCancelCause(cmdCtx)
+	return &syncLock{
+		key: path,
+		value: string(value),
+		file: f,
+		cancel: cancel,
+	}, lockCtx, nil
+}
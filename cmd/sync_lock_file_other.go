@@ -0,0 +1,16 @@
+//go:build !unix
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+)
+
+func lockFile(f *os.File) (bool, error) {
+	return false, fmt.Errorf("Local sync locks are not supported on this platform, configure Redis")
+}
+
+func unlockFile(f *os.File) error {
+	return nil
+}
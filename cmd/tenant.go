@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// ANNOTATION_TENANT_OPTIONAL marks a command in its Annotations as working
+// without a tenant, such as `auth status` listing every stored token.
+const ANNOTATION_TENANT_OPTIONAL = "tenant_optional"
+
+// tenant is the DirectCloud company code the command works on. Every mirrored
+// document carries it, so several companies can share one database.
+var tenant string
+
+func initTenant(cmd *cobra.Command) error {
+	tenant = flagOrEnv(cmd, "tenant", "DIRECTCLOUD_CODE")
+	if _, optional := cmd.Annotations[ANNOTATION_TENANT_OPTIONAL]; tenant == "" && !optional {
+		return fmt.Errorf("Tenant is not set: pass --tenant or set DIRECTCLOUD_CODE")
+	}
+	return nil
+}
+
+// checkSyncTenant makes sure tokens and synced data are stored under the
+// company the admin credentials belong to.
+func checkSyncTenant() error {
+	code := os.Getenv("DIRECTCLOUD_CODE")
+	if code != "" && code != tenant {
+		return fmt.Errorf("--tenant %q does not match DIRECTCLOUD_CODE %q; use the profile of that tenant", tenant, code)
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.40.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	TRACE_EXPORTER_NONE = "none"
+	TRACE_EXPORTER_OTLP = "otlp"
+	TRACE_EXPORTER_FILE = "file"
+	TRACING_SERVICE_NAME = "amazing-brain-dead-storage-accessor"
+)
+
+var (
+	tracer = otel.Tracer("github.com/xxuxa-k/amazing-brain-dead-storage-accessor/cmd")
+	tracerProvider *sdktrace.TracerProvider
+	traceFile *os.File
+	commandSpan trace.Span
+)
+
+// initTracing installs the tracer provider selected by --trace-exporter and
+// starts the span that covers the whole command. The OTLP exporter is
+// configured through the standard OTEL_EXPORTER_OTLP_* environment variables.
+func initTracing(cmd *cobra.Command) error {
+	cmdCtx := cmd.Context()
+	kind, _ := cmd.Flags().GetString("trace-exporter")
+	if kind == "" {
+		kind = os.Getenv("TRACE_EXPORTER")
+	}
+	var exporter sdktrace.SpanExporter
+	switch kind {
+	case TRACE_EXPORTER_NONE, "":
+		return nil
+	case TRACE_EXPORTER_OTLP:
+		exp, err := otlptracehttp.New(cmdCtx)
+		if err != nil {
+			return fmt.Errorf("Failed to create OTLP trace exporter: %w", err)
+		}
+		exporter = exp
+	case TRACE_EXPORTER_FILE:
+		path, _ := cmd.Flags().GetString("trace-file")
+		if path == "" {
+			now := time.Now().In(time.Local).Format(time.DateOnly)
+			path = filepath.Join(DIRECTORY_DEFAULT_LOGS, fmt.Sprintf("trace-%s.jsonl", now))
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return fmt.Errorf("Failed to create trace directory: %w", err)
+		}
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("Failed to open trace file: %w", err)
+		}
+		traceFile = f
+		exp, err := stdouttrace.New(stdouttrace.WithWriter(f))
+		if err != nil {
+			return fmt.Errorf("Failed to create file trace exporter: %w", err)
+		}
+		exporter = exp
+	default:
+		return fmt.Errorf("Unknown trace exporter %q (expected %s, %s or %s)",
+			kind, TRACE_EXPORTER_NONE, TRACE_EXPORTER_OTLP, TRACE_EXPORTER_FILE)
+	}
+	res, err := resource.Merge(
+		resource.Default(),
+		resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceName(TRACING_SERVICE_NAME),
+			attribute.String("session.id", sessionID),
+			),
+		)
+	if err != nil {
+		return fmt.Errorf("Failed to build trace resource: %w", err)
+	}
+	tracerProvider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		)
+	otel.SetTracerProvider(tracerProvider)
+
+	ctx, span := tracer.Start(cmdCtx, cmd.CommandPath(),
+		trace.WithAttributes(
+			attribute.String("session.id", sessionID),
+			),
+		)
+	commandSpan = span
+	cmd.SetContext(ctx)
+	slog.DebugContext(cmdCtx, "Tracing enabled",
+		"exporter", kind,
+		"traceID", span.SpanContext().TraceID().String(),
+		)
+	return nil
+}
+
+// closeTracing ends the command span and flushes pending spans. It is called
+// from Execute so that failed commands are exported too.
+func closeTracing(cmdErr error) error {
+	if tracerProvider == nil {
+		return nil
+	}
+	if commandSpan != nil {
+		recordSpanError(commandSpan, cmdErr)
+		commandSpan.End()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err := tracerProvider.Shutdown(ctx)
+	if traceFile != nil {
+		if closeErr := traceFile.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+func recordSpanError(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
@@ -1,22 +1,67 @@
 package cmd
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DIRECTCLOUD_DEFAULT_API_BASE_URL = "https://api.directcloud.jp"
+	// DIRECTCLOUD_DEFAULT_FILES_LIST_PATH is the file listing of the
+	// DirectCloud OpenAPI. It answers like the sharedbox listing, with the
+	// files in lists and paging through limit and offset.
+	DIRECTCLOUD_DEFAULT_FILES_LIST_PATH = "/openapp/v1/files/index/"
+	// DIRECTCLOUD_DEFAULT_FILES_DOWNLOAD_PATH is the file download of the
+	// DirectCloud OpenAPI, which takes the file as file_seq.
+	DIRECTCLOUD_DEFAULT_FILES_DOWNLOAD_PATH = "/openapp/v1/files/download/"
+	// FILE_DOWNLOAD_ERROR_PEEK bounds how much of a JSON download is read to
+	// tell an API error from a .json file.
+	FILE_DOWNLOAD_ERROR_PEEK = 64 << 10
+	DIRECTCLOUD_FILES_PAGE_SIZE = 1000
 )
 
 type AdminApiClient struct {
 	AccessToken string
+	BaseURL     string
+	// FilesListPath is the file listing endpoint. DIRECTCLOUD_FILES_LIST_PATH
+	// overrides it for contracts that expose it elsewhere.
+	FilesListPath string
+	// FileDownloadPath is the download endpoint, overridden by
+	// DIRECTCLOUD_FILES_DOWNLOAD_PATH.
+	FileDownloadPath string
 	httpClient  *http.Client
 }
+// directcloudBaseURL returns DIRECTCLOUD_API_BASE_URL or the public API.
+func directcloudBaseURL() string {
+	return envOrDefault("DIRECTCLOUD_API_BASE_URL", DIRECTCLOUD_DEFAULT_API_BASE_URL)
+}
+func envOrDefault(env string, def string) string {
+	if v := os.Getenv(env); v != "" {
+		return v
+	}
+	return def
+}
 func NewAdminApiClient(token string) *AdminApiClient {
 	return &AdminApiClient{
 		AccessToken: token,
-		httpClient: &http.Client{},
+		BaseURL: directcloudBaseURL(),
+		FilesListPath: envOrDefault("DIRECTCLOUD_FILES_LIST_PATH", DIRECTCLOUD_DEFAULT_FILES_LIST_PATH),
+		FileDownloadPath: envOrDefault("DIRECTCLOUD_FILES_DOWNLOAD_PATH", DIRECTCLOUD_DEFAULT_FILES_DOWNLOAD_PATH),
+		httpClient: &http.Client{
+			Transport: &instrumentedTransport{
+				next: http.DefaultTransport,
+			},
+		},
 	}
 }
 func (c *AdminApiClient) NewGetRequest(url string) (*http.Request, error) {
@@ -27,44 +72,161 @@ func (c *AdminApiClient) NewGetRequest(url string) (*http.Request, error) {
 	req.Header.Set("access_token", c.AccessToken)
 	return req, nil
 }
+// doGet sends req and decodes the JSON body into out. Failures, including
+// 200 responses with success:false, are returned as *APIError.
+func (c *AdminApiClient) doGet(ctx context.Context, req *http.Request, out any) error {
+	endpoint := apiEndpointLabel(req.URL.Path)
+	req = req.WithContext(ctx)
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("Failed to send GET request: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("Failed to read response body: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return newAPIError(endpoint, resp, body)
+	}
+	var status AdminAuthTokenErrorResponse
+	if err := json.Unmarshal(body, &status); err != nil {
+		return fmt.Errorf("Failed to parse response JSON: %w", err)
+	}
+	if !status.Success {
+		return newAPIError(endpoint, resp, body)
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("Failed to parse response JSON: %w", err)
+	}
+	return nil
+}
 func (c *AdminApiClient) UsersList(
 	ctx context.Context,
 ) (UserListResponse, error) {
 	var result UserListResponse
-	u, _ := url.Parse("https://api.directcloud.jp/openapp/m1/users/lists/")
+	u, err := url.Parse(c.BaseURL + "/openapp/m1/users/lists/")
+	if err != nil {
+		return result, fmt.Errorf("Failed to parse URL: %w", err)
+	}
 	params := url.Values{}
 	params.Add("lang", "eng")
 	params.Add("limit", "1000")
 	u.RawQuery = params.Encode()
-	req, err := adminApiClient.NewGetRequest(u.String())
+	req, err := c.NewGetRequest(u.String())
 	if err != nil {
 		return result, fmt.Errorf("Failed to create GET request: %w", err)
 	}
-	req = req.WithContext(ctx)
-	resp, err := adminApiClient.httpClient.Do(req)
+	err = c.doGet(ctx, req, &result)
+	return result, err
+}
+
+// FilesList returns one page of the files directly inside node.
+func (c *AdminApiClient) FilesList(
+	ctx context.Context,
+	node string,
+	offset int,
+) (FileListResponse, error) {
+	var result FileListResponse
+	joined, err := url.JoinPath(c.BaseURL, c.FilesListPath, node)
 	if err != nil {
-		return result, fmt.Errorf("Failed to send GET request: %w", err)
+		return result, fmt.Errorf("Failed to join URL path: %w", err)
 	}
-	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
+	u, err := url.Parse(joined)
 	if err != nil {
-		return result, fmt.Errorf("Failed to read response body: %v", err)
+		return result, fmt.Errorf("Failed to parse URL: %w", err)
 	}
-	if resp.StatusCode != http.StatusOK {
-		return result, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+	params := url.Values{}
+	params.Add("lang", "eng")
+	params.Add("limit", strconv.Itoa(DIRECTCLOUD_FILES_PAGE_SIZE))
+	params.Add("offset", strconv.Itoa(offset))
+	u.RawQuery = params.Encode()
+	req, err := c.NewGetRequest(u.String())
+	if err != nil {
+		return result, fmt.Errorf("Failed to create GET request: %w", err)
+	}
+	err = c.doGet(ctx, req, &result)
+	return result, err
+}
+// FileDownload starts downloading a file, from offset on when offset is
+// positive. The caller must check for 206 Partial Content before appending
+// and close the body: a server that ignores Range answers 200 with the whole
+// file. Failures are returned as *APIError.
+func (c *AdminApiClient) FileDownload(
+	ctx context.Context,
+	node string,
+	fileSeq string,
+	offset int64,
+) (*http.Response, error) {
+	joined, err := url.JoinPath(c.BaseURL, c.FileDownloadPath, node)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to join URL path: %w", err)
+	}
+	u, err := url.Parse(joined)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse URL: %w", err)
+	}
+	params := url.Values{}
+	params.Add("file_seq", fileSeq)
+	u.RawQuery = params.Encode()
+	req, err := c.NewGetRequest(u.String())
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create GET request: %w", err)
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := c.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to send GET request: %w", err)
 	}
-	if err := json.Unmarshal(body, &result); err != nil {
-		return result, fmt.Errorf("Failed to parse response JSON: %w", err)
+	endpoint := apiEndpointLabel(req.URL.Path)
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		defer resp.Body.Close()
+		body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read response body: %w", err)
+		}
+		return nil, newAPIError(endpoint, resp, body)
 	}
-	return result, nil
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		return resp, nil
+	}
+	// Errors can come back as a 200 JSON body instead of the file, but so
+	// can a .json file. Only a small body saying success:false is an error.
+	head, err := io.ReadAll(io.LimitReader(resp.Body, FILE_DOWNLOAD_ERROR_PEEK+1))
+	if err != nil {
+		resp.Body.Close()
+		return nil, &url.Error{Op: "Get", URL: u.String(), Err: err}
+	}
+	if len(head) <= FILE_DOWNLOAD_ERROR_PEEK && isAPIFailureBody(head) {
+		resp.Body.Close()
+		return nil, newAPIError(endpoint, resp, head)
+	}
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(head), resp.Body), resp.Body}
+	return resp, nil
+}
+
+// isAPIFailureBody reports whether body is a JSON object with success set
+// to false, the shape of every DirectCloud error.
+func isAPIFailureBody(body []byte) bool {
+	var status struct {
+		Success *bool `json:"success"`
+	}
+	if err := json.Unmarshal(body, &status); err != nil {
+		return false
+	}
+	return status.Success != nil && !*status.Success
 }
 func (c *AdminApiClient) SharedboxesList(
 	ctx context.Context,
 	node string,
 ) (SharedBoxListResponse, error) {
 	var result SharedBoxListResponse
-	baseURL := "https://api.directcloud.jp/openapp/m1/sharedboxes/lists/"
-	joined, err := url.JoinPath(baseURL, node)
+	joined, err := url.JoinPath(c.BaseURL, "/openapp/m1/sharedboxes/lists/", node)
 	if err != nil {
 		return result, fmt.Errorf("Failed to join URL path: %w", err)
 	}
@@ -75,27 +237,12 @@ func (c *AdminApiClient) SharedboxesList(
 	params := url.Values{}
 	params.Add("lang", "eng")
 	u.RawQuery = params.Encode()
-	req, err := adminApiClient.NewGetRequest(u.String())
+	req, err := c.NewGetRequest(u.String())
 	if err != nil {
 		return result, fmt.Errorf("Failed to create GET request: %w", err)
 	}
-	req = req.WithContext(ctx)
-	resp, err := adminApiClient.httpClient.Do(req)
-	if err != nil {
-		return result, fmt.Errorf("Failed to send GET request: %w", err)
-	}
-	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return result, fmt.Errorf("Failed to read response body: %v", err)
-	}
-	if resp.StatusCode != http.StatusOK {
-		return result, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
-	}
-	if err := json.Unmarshal(body, &result); err != nil {
-		return result, fmt.Errorf("Failed to parse response JSON: %w", err)
-	}
-	return result, nil
+	err = c.doGet(ctx, req, &result)
+	return result, err
 }
 
 type AdminAuthTokenResponse struct {
@@ -104,6 +251,8 @@ type AdminAuthTokenResponse struct {
 	Expire string `json:"expire"`
 	ExpireTimestamp int `json:"expire_timestamp"`
 }
+// AdminAuthTokenErrorResponse is the error body of every endpoint, not only
+// the token endpoint.
 type AdminAuthTokenErrorResponse struct {
 	Success bool `json:"success"`
 	All string `json:"all"`
@@ -125,6 +274,14 @@ type UserListItem struct {
 	Status int `json:"status" bson:"status"`
 	RegDate string `json:"regdate" bson:"regdate"`
 }
+// UserDocument is a user as stored in the users collection.
+// SchemaVersion is bumped together with a backfill migration whenever a
+// field is added, so documents written by older versions are upgraded.
+type UserDocument struct {
+	Tenant string `json:"tenant" bson:"tenant"`
+	UserListItem `bson:",inline"`
+	SchemaVersion int `json:"-" bson:"schema_version"`
+}
 type SharedBoxListResponse struct {
 	Success bool `json:"success"`
 	Total int `json:"total"`
@@ -137,9 +294,97 @@ type SharedBoxListItem struct {
 	DrivePath string `json:"drive_path" bson:"drive_path"`
 }
 
+// SharedBoxListItemWithParent is a sharedbox node as stored in the
+// sharedboxes collection. See UserDocument for SchemaVersion.
 type SharedBoxListItemWithParent struct {
+	Tenant string `json:"tenant" bson:"tenant"`
 	Item SharedBoxListItem `json:"item" bson:"item"`
 	ParentNode string `json:"parent_node" bson:"parent_node"`
+	SchemaVersion int `json:"-" bson:"schema_version"`
+}
+
+type FileListResponse struct {
+	Success bool `json:"success"`
+	Total int `json:"total"`
+	Lists []FileListItem `json:"lists"`
+}
+type FileListItem struct {
+	FileSeq apiString `json:"file_seq" bson:"file_seq"`
+	Name string `json:"name" bson:"name"`
+	Size apiInt64 `json:"size" bson:"size"`
+	// Datetime is the last modification time in JST, "2006-01-02 15:04:05".
+	Datetime string `json:"datetime" bson:"datetime"`
+}
+
+// FileDocument is a file as stored in the files collection.
+type FileDocument struct {
+	Tenant string `json:"tenant" bson:"tenant"`
+	FileListItem `bson:",inline"`
+	Extension string `json:"extension" bson:"extension"`
+	ModifiedAt time.Time `json:"modified_at" bson:"modified_at"`
+	ParentNode string `json:"parent_node" bson:"parent_node"`
+	SchemaVersion int `json:"-" bson:"schema_version"`
+}
+
+// apiString and apiInt64 accept both JSON strings and numbers, as the API
+// is not consistent about quoting numeric fields.
+type apiString string
+func (s *apiString) UnmarshalJSON(b []byte) error {
+	var v any
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	switch v := v.(type) {
+	case nil:
+		*s = ""
+	case string:
+		*s = apiString(v)
+	default:
+		*s = apiString(strings.TrimSpace(string(b)))
+	}
+	return nil
+}
+
+type apiInt64 int64
+func (n *apiInt64) UnmarshalJSON(b []byte) error {
+	var v any
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	switch v := v.(type) {
+	case nil:
+		*n = 0
+	case string:
+		if v == "" {
+			*n = 0
+			return nil
+		}
+		i, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q: %w", v, err)
+		}
+		*n = apiInt64(i)
+	case float64:
+		*n = apiInt64(v)
+	default:
+		return fmt.Errorf("invalid number %s", b)
+	}
+	return nil
+}
+
+// directcloudLocation is the time zone API timestamps are given in.
+var directcloudLocation = time.FixedZone("JST", 9*60*60)
+
+func newFileDocument(tenant string, parentNode string, item FileListItem) FileDocument {
+	modifiedAt, _ := time.ParseInLocation(time.DateTime, item.Datetime, directcloudLocation)
+	return FileDocument{
+		Tenant: tenant,
+		FileListItem: item,
+		Extension: strings.ToLower(strings.TrimPrefix(path.Ext(item.Name), ".")),
+		ModifiedAt: modifiedAt,
+		ParentNode: parentNode,
+		SchemaVersion: FILE_SCHEMA_VERSION,
+	}
 }
 
 type SharedBoxHierarchy struct {
@@ -150,6 +395,10 @@ type SharedBoxHierarchy struct {
 type NodeError struct {
 	Node string
 	Err error
+	// Attempt is the number of API calls made for the node.
+	Attempt int
+	// Class overrides the class derived from Err by classifyError.
+	Class string
 }
 func (e *NodeError) Error() string {
 	return fmt.Sprintf("NodeError: node=%s, err=%v", e.Node, e.Err)
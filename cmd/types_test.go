@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFileDownloadJSON(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		body string
+		wantErr bool
+	}{
+		{name: "API error", body: `{"success":false,"all":"file not found"}`, wantErr: true},
+		{name: "JSON file", body: `{"success":true,"items":[1,2,3]}`},
+		{name: "JSON file without success", body: `[{"success":false}]`},
+		{name: "not JSON", body: `{"success":false`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				io.WriteString(w, tc.body)
+			}))
+			defer srv.Close()
+			client := NewAdminApiClient("token")
+			client.BaseURL = srv.URL
+			resp, err := client.FileDownload(context.Background(), "node", "1", 0)
+			if tc.wantErr {
+				var apiErr *APIError
+				if !errors.As(err, &apiErr) {
+					t.Fatalf("expected an *APIError, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			got, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tc.body {
+				t.Errorf("body %q, expected %q", got, tc.body)
+			}
+		})
+	}
+}
+
+func TestApiString(t *testing.T) {
+	for _, tc := range []struct {
+		in string
+		want apiString
+		wantErr bool
+	}{
+		{in: `"123"`, want: "123"},
+		{in: `123`, want: "123"},
+		{in: `12345678901234567890`, want: "12345678901234567890"},
+		{in: `null`, want: ""},
+		{in: `""`, want: ""},
+		{in: `{`, wantErr: true},
+	} {
+		var got apiString
+		err := json.Unmarshal([]byte(tc.in), &got)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("unmarshal %s: expected an error, got %q", tc.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Errorf("unmarshal %s = %q, %v, expected %q", tc.in, got, err, tc.want)
+		}
+	}
+}
+
+func TestApiInt64(t *testing.T) {
+	for _, tc := range []struct {
+		in string
+		want apiInt64
+		wantErr bool
+	}{
+		{in: `1024`, want: 1024},
+		{in: `"1024"`, want: 1024},
+		{in: `""`, want: 0},
+		{in: `null`, want: 0},
+		{in: `"1.5"`, wantErr: true},
+		{in: `"abc"`, wantErr: true},
+		{in: `true`, wantErr: true},
+	} {
+		var got apiInt64
+		err := json.Unmarshal([]byte(tc.in), &got)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("unmarshal %s: expected an error, got %d", tc.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Errorf("unmarshal %s = %d, %v, expected %d", tc.in, got, err, tc.want)
+		}
+	}
+}
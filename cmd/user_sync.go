@@ -7,12 +7,13 @@ import (
 	"time"
 
 	"github.com/spf13/cobra"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var userSyncCmd = &cobra.Command{
 	Use:   "sync",
+	Annotations: withBackends(BACKEND_STORAGE_WRITE),
 	PreRunE: func(cmd *cobra.Command, args []string) error {
 		if err := initAdminApiClient(); err != nil {
 			return fmt.Errorf("Failed to initialize Admin API client: %v", err)
@@ -21,42 +22,97 @@ var userSyncCmd = &cobra.Command{
 		return nil
 	},
 	RunE: func(cmd *cobra.Command, args []string) error {
-		cmdCtx := cmd.Context()
-		resp, err := adminApiClient.UsersList(cmdCtx)
-		if err != nil {
-			slog.ErrorContext(cmdCtx, "Failed to list users", "error", err)
-			return err
-		}
-		if len(resp.Lists) > 0 {
-			slog.DebugContext(cmdCtx, "user in list", 
-				"count", len(resp.Lists),
-				)
-			collection := mongoClient.Database(mongoDatabase).Collection(MONGO_COLLECTION_USERS)
-			var writeModels []mongo.WriteModel
-			ctx, cancel := context.WithTimeout(cmdCtx, 30*time.Second)
-			defer cancel()
-			for _, user := range resp.Lists {
-				model := mongo.NewReplaceOneModel().SetFilter(bson.M{
-					"user_seq": user.UserSeq,
-				}).SetReplacement(user).SetUpsert(true)
-				writeModels = append(writeModels, model)
-			}
-			result, err := collection.BulkWrite(ctx, writeModels)
-			if err != nil {
-				slog.ErrorContext(ctx, "Bulk write error", "error", err)
-				return err
-			}
-			slog.DebugContext(cmdCtx, "Bulk write result",
-				"insertedCount", result.InsertedCount,
-				"modifiedCount", result.ModifiedCount,
-				"upsertedCount", result.UpsertedCount,
-				"matchedCount", result.MatchedCount,
-				)
-		}
-		return nil
+		return runUserSync(cmd.Context(), adminApiClient, userSyncOptions{
+			Lock: syncLockOptionsFromFlags(cmd),
+		})
 	},
 }
 
 func init() {
+	addSyncLockFlags(userSyncCmd)
+}
+
+type userSyncOptions struct {
+	Lock syncLockOptions
 }
 
+func runUserSync(
+	ctx context.Context,
+	client *AdminApiClient,
+	opts userSyncOptions,
+) (err error) {
+	defer func() {
+		observeSyncRun("user", err)
+	}()
+	if err := checkSyncTenant(); err != nil {
+		return err
+	}
+	if err := initSyncRedis(ctx, false); err != nil {
+		return err
+	}
+	lock, cmdCtx, err := acquireSyncLock(ctx, opts.Lock, "user", "")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := lock.Release(); err != nil {
+			slog.ErrorContext(ctx, "Failed to release sync lock",
+				"error", err,
+				)
+		}
+	}()
+	var resp UserListResponse
+	_, err = withAPIRetry(cmdCtx, func() error {
+		resp, err = client.UsersList(cmdCtx)
+		return err
+	})
+	if err != nil {
+		slog.ErrorContext(cmdCtx, "Failed to list users", "error", err)
+		return err
+	}
+	if len(resp.Lists) > 0 {
+		slog.DebugContext(cmdCtx, "user in list",
+			"count", len(resp.Lists),
+			)
+		var users []UserDocument
+		ctx, cancel := context.WithTimeout(cmdCtx, 30*time.Second)
+		defer cancel()
+		ctx, span := tracer.Start(ctx, "storage.bulk_upsert",
+			trace.WithAttributes(
+				attribute.String("storage", storageBackend),
+				attribute.String("collection", MONGO_COLLECTION_USERS),
+				attribute.Int("batch_size", len(resp.Lists)),
+				),
+			)
+		defer span.End()
+		for _, user := range resp.Lists {
+			users = append(users, UserDocument{
+				Tenant: tenant,
+				UserListItem: user,
+				SchemaVersion: USER_SCHEMA_VERSION,
+			})
+		}
+		result, err := store.UpsertUsers(ctx, users)
+		if err != nil {
+			recordSpanError(span, err)
+			observeBulkWrite(MONGO_COLLECTION_USERS, 0, 0, 0, 0, err)
+			slog.ErrorContext(ctx, "Bulk write error", "error", err)
+			return err
+		}
+		observeBulkWrite(
+			MONGO_COLLECTION_USERS,
+			result.Inserted,
+			result.Modified,
+			result.Upserted,
+			result.Matched,
+			nil,
+			)
+		slog.DebugContext(cmdCtx, "Bulk write result",
+			"insertedCount", result.Inserted,
+			"modifiedCount", result.Modified,
+			"upsertedCount", result.Upserted,
+			"matchedCount", result.Matched,
+			)
+	}
+	return nil
+}